@@ -0,0 +1,136 @@
+// Package logging provides a middleware that writes one structured slog record per event: group, user, a hash or
+// snippet of the text, how many replies were sent, whether BlockNext fired, and the duration. Panics in the chain are
+// recovered and logged. Inject globally, e.g. zerobot.InstallWithMiddlewares([]protocol.Middleware{logging.New(logging.Config{})}).
+//
+// Naming handlers is opt-in: wrap one with Named or ForPlugin(Meta), e.g. p.OnMessage().Func(logging.ForPlugin(Meta)(h)),
+// and the record lists it under replied_by when it sends, blocked_by when it calls BlockNext and panic_handler when it
+// panics. Only what a handler did is recorded, not that it ran, since Builder conditions are checked before it is called.
+package logging
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	mrand "math/rand/v2"
+	"os"
+	"runtime/debug"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Hafuunano/Core-SkillAction/types"
+	"github.com/Hafuunano/Protocol-ConvertTool/protocol"
+)
+
+const defaultSnippetLen = 40 // runes
+
+// Meta is this middleware's metadata for config (MiddlewareName etc.).
+var Meta = types.MiddlewareEngine{
+	MiddlewareID:   "middleware-logging",
+	MiddlewareName: "logging",
+}
+
+// Config controls what is logged and where.
+type Config struct {
+	// Logger receives the records. Nil builds a JSON logger writing to Output.
+	Logger *slog.Logger
+	// Output is used when Logger is nil; nil means stderr. Use a *RotatingFile for size-based rotation.
+	Output io.Writer
+	// SampleRate is the fraction of events logged, in (0, 1]; 0 means 1. Panics are always logged.
+	SampleRate float64
+	// LogText logs a snippet of the message text; by default only its length and a keyed hash are logged (see RedactedText).
+	LogText bool
+	// SnippetLen is the snippet length in runes when LogText is set; 0 means 40.
+	SnippetLen int
+}
+
+// New returns a protocol.Middleware that logs every event passing through it with cfg.
+func New(cfg Config) protocol.Middleware {
+	logger := cfg.Logger
+	if logger == nil {
+		out := cfg.Output
+		if out == nil {
+			out = os.Stderr
+		}
+		logger = slog.New(slog.NewJSONHandler(out, nil))
+	}
+	rate := cfg.SampleRate
+	if rate <= 0 || rate > 1 {
+		rate = 1
+	}
+	snippet := cfg.SnippetLen
+	if snippet <= 0 {
+		snippet = defaultSnippetLen
+	}
+	return func(next protocol.Handler) protocol.Handler {
+		return func(ctx protocol.Context) {
+			tc := newTracedContext(ctx)
+			start := time.Now()
+			defer func() {
+				r := recover()
+				if r == nil && rate < 1 && mrand.Float64() >= rate {
+					return
+				}
+				attrs := []slog.Attr{
+					slog.String("group", ctx.GroupID()),
+					slog.String("user", ctx.UserID()),
+					textAttr(ctx.PlainText(), cfg.LogText, snippet),
+				}
+				replies, senders, blockedBy, blocked := tc.trace.snapshot()
+				attrs = append(attrs, slog.Int("replies", replies), slog.Bool("block_next", blocked))
+				if len(senders) > 0 {
+					attrs = append(attrs, slog.Any("replied_by", senders))
+				}
+				if blockedBy != "" {
+					attrs = append(attrs, slog.String("blocked_by", blockedBy))
+				}
+				attrs = append(attrs, slog.Duration("duration", time.Since(start)))
+				if r != nil {
+					attrs = append(attrs, slog.Any("panic", r))
+					if name := tc.trace.current(); name != "" {
+						attrs = append(attrs, slog.String("panic_handler", name))
+					}
+					attrs = append(attrs, slog.String("stack", string(debug.Stack())))
+					logger.LogAttrs(context.Background(), slog.LevelError, "event panicked", attrs...)
+					return
+				}
+				logger.LogAttrs(context.Background(), slog.LevelInfo, "event", attrs...)
+			}()
+			next(tc)
+		}
+	}
+}
+
+// textAttr describes text as RedactedText, or as a snippet of at most snippet runes when withText is set.
+func textAttr(text string, withText bool, snippet int) slog.Attr {
+	if !withText {
+		return RedactedText("text", text)
+	}
+	if utf8.RuneCountInString(text) > snippet {
+		text = string([]rune(text)[:snippet]) + "…"
+	}
+	return slog.String("text", text)
+}
+
+// redactKey keys RedactedText's hash. It is random per process, so a hash cannot be reversed with a dictionary of
+// likely messages ("+1", a group password) and only matches other records written by the same process.
+var redactKey = func() []byte {
+	k := make([]byte, 32)
+	_, _ = rand.Read(k)
+	return k
+}()
+
+// RedactedText logs text without its content: its length in runes and an HMAC-SHA256 prefix under a per-process
+// random key, enough to spot repeats within one run. Shared with plugins that log message text, so every record
+// uses the same unit and hash.
+func RedactedText(key, text string) slog.Attr {
+	mac := hmac.New(sha256.New, redactKey)
+	mac.Write([]byte(text))
+	return slog.Group(key,
+		slog.Int("len", utf8.RuneCountInString(text)),
+		slog.String("hmac", hex.EncodeToString(mac.Sum(nil)[:8])),
+	)
+}
@@ -0,0 +1,111 @@
+package logging
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Hafuunano/Protocol-ConvertTool/protocol"
+)
+
+// fakeContext implements the parts of protocol.Context the middleware reads; calling anything else panics.
+type fakeContext struct {
+	protocol.Context
+	blocked bool
+}
+
+func (c *fakeContext) UserID() string                { return "u1" }
+func (c *fakeContext) GroupID() string               { return "g1" }
+func (c *fakeContext) PlainText() string             { return "secret text" }
+func (c *fakeContext) SendPlainMessage(string) error { return nil }
+func (c *fakeContext) BlockNext()                    { c.blocked = true }
+func (c *fakeContext) ShouldBlockNext() bool         { return c.blocked }
+
+// record runs handlers as one chain under New and returns the decoded log record.
+func record(t *testing.T, handlers ...protocol.Handler) map[string]any {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := New(Config{Logger: slog.New(slog.NewJSONHandler(&buf, nil))})
+	mw(func(ctx protocol.Context) {
+		for _, h := range handlers {
+			h(ctx)
+			if ctx.ShouldBlockNext() {
+				return
+			}
+		}
+	})(&fakeContext{})
+	var rec map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatalf("decode %q: %v", buf.String(), err)
+	}
+	return rec
+}
+
+func reply(ctx protocol.Context) { _ = ctx.SendPlainMessage("hi") }
+func idle(protocol.Context)      {}
+func block(ctx protocol.Context) { ctx.BlockNext() }
+
+func names(v any) []string {
+	var out []string
+	for _, s := range v.([]any) {
+		out = append(out, s.(string))
+	}
+	return out
+}
+
+func TestRecordCountsRepliesAndNamesOnlyOptedInHandlers(t *testing.T) {
+	rec := record(t, idle, Named("idle", idle), reply, Named("named", reply), Named("named", reply), Named("blocker", block), Named("after", reply))
+	if rec["replies"] != float64(3) {
+		t.Errorf("replies = %v, want 3", rec["replies"])
+	}
+	if got := names(rec["replied_by"]); !slices.Equal(got, []string{"named"}) {
+		t.Errorf("replied_by = %v, want [named]", got)
+	}
+	if rec["block_next"] != true || rec["blocked_by"] != "blocker" {
+		t.Errorf("block_next = %v, blocked_by = %v", rec["block_next"], rec["blocked_by"])
+	}
+	if _, ok := rec["handlers"]; ok {
+		t.Error("record lists handlers that merely ran")
+	}
+	text, _ := rec["text"].(map[string]any)
+	if text == nil || text["len"] != float64(len("secret text")) {
+		t.Errorf("text = %v, want redacted length", rec["text"])
+	}
+}
+
+func TestRecordWithoutNamedHandlers(t *testing.T) {
+	rec := record(t, reply, block)
+	if rec["replies"] != float64(1) || rec["block_next"] != true {
+		t.Errorf("replies = %v, block_next = %v", rec["replies"], rec["block_next"])
+	}
+	for _, key := range []string{"replied_by", "blocked_by"} {
+		if _, ok := rec[key]; ok {
+			t.Errorf("%s logged without named handlers: %v", key, rec[key])
+		}
+	}
+}
+
+func TestRecordPanicAttributedToNamedHandler(t *testing.T) {
+	rec := record(t, Named("ok", idle), Named("boom", func(protocol.Context) { panic("boom") }))
+	if rec["level"] != "ERROR" || rec["panic"] != "boom" || rec["panic_handler"] != "boom" {
+		t.Errorf("level = %v, panic = %v, panic_handler = %v", rec["level"], rec["panic"], rec["panic_handler"])
+	}
+}
+
+func TestRedactedTextIsKeyed(t *testing.T) {
+	hash := func(text string) string {
+		return RedactedText("text", text).Value.Group()[1].Value.String()
+	}
+	if hash("+1") != hash("+1") || hash("+1") == hash("+2") {
+		t.Fatal("hash does not identify repeats")
+	}
+	sum := sha256.Sum256([]byte("+1"))
+	if strings.HasPrefix(hex.EncodeToString(sum[:]), hash("+1")) {
+		t.Fatal("hash is a plain SHA-256 prefix")
+	}
+}
@@ -0,0 +1,110 @@
+package logging
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// RotatingFile is an io.WriteCloser appending to path and rotating it once it would exceed MaxBytes:
+// path becomes path.1, path.1 becomes path.2, ..., keeping at most MaxBackups old files.
+type RotatingFile struct {
+	path       string
+	maxBytes   int64
+	maxBackups int
+
+	mu     sync.Mutex
+	f      *os.File // nil after a failed reopen; the next Write retries
+	size   int64
+	closed bool
+	// retryAt holds off rotation after a failure until the file has grown another maxBytes,
+	// so a persistent failure surfaces once per maxBytes rather than on every write.
+	retryAt int64
+}
+
+// NewRotatingFile opens (or creates) path for appending. maxBytes <= 0 disables rotation; maxBackups < 1 keeps one backup.
+func NewRotatingFile(path string, maxBytes int64, maxBackups int) (*RotatingFile, error) {
+	if path == "" {
+		return nil, errors.New("logging: empty log file path")
+	}
+	r := &RotatingFile{path: path, maxBytes: maxBytes, maxBackups: max(maxBackups, 1)}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	r.f, r.size = f, info.Size()
+	return nil
+}
+
+// Write implements io.Writer. A single record larger than MaxBytes is still written whole, to a fresh file.
+// If rotation fails the record is appended to path anyway and the error is returned for this write only;
+// rotation is retried once the file has grown another MaxBytes.
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return 0, os.ErrClosed
+	}
+	var rotateErr error
+	if next := r.size + int64(len(p)); r.f != nil && r.maxBytes > 0 && r.size > 0 && next > r.maxBytes && next > r.retryAt {
+		rotateErr = r.rotate()
+	}
+	if r.f == nil {
+		if err := r.open(); err != nil {
+			return 0, errors.Join(rotateErr, err)
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	if rotateErr != nil {
+		r.retryAt = r.size + r.maxBytes
+	}
+	return n, errors.Join(rotateErr, err)
+}
+
+// rotate shifts the backups and reopens path. Caller holds mu. On failure r.f is left nil
+// and the caller reopens path, so a failed rotation never stops logging.
+func (r *RotatingFile) rotate() error {
+	closeErr := r.f.Close()
+	r.f = nil
+	backup := func(i int) string { return r.path + "." + strconv.Itoa(i) }
+	_ = os.Remove(backup(r.maxBackups))
+	for i := r.maxBackups - 1; i >= 1; i-- {
+		_ = os.Rename(backup(i), backup(i+1))
+	}
+	if err := os.Rename(r.path, backup(1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return errors.Join(closeErr, err)
+	}
+	if err := r.open(); err != nil {
+		return errors.Join(closeErr, err)
+	}
+	r.retryAt = 0
+	return closeErr
+}
+
+// Close implements io.Closer.
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed || r.f == nil {
+		r.closed = true
+		return nil
+	}
+	r.closed = true
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
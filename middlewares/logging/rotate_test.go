@@ -0,0 +1,98 @@
+package logging
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read %s: %v", path, err)
+	}
+	return string(b)
+}
+
+func TestRotatingFileShiftsBackups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bot.log")
+	r, err := NewRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	for _, rec := range []string{"aaaaaaaa\n", "bbbbbbbb\n", "cccccccc\n", "dddddddd\n"} {
+		if _, err := r.Write([]byte(rec)); err != nil {
+			t.Fatalf("write %q: %v", rec, err)
+		}
+	}
+	want := map[string]string{path: "dddddddd\n", path + ".1": "cccccccc\n", path + ".2": "bbbbbbbb\n"}
+	for p, content := range want {
+		if got := readFile(t, p); got != content {
+			t.Errorf("%s = %q, want %q", filepath.Base(p), got, content)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("bot.log.3 exists beyond maxBackups: %v", err)
+	}
+}
+
+func TestRotatingFileKeepsWritingWhenRotationFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bot.log")
+	r, err := NewRotatingFile(path, 10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	// A non-empty directory in place of bot.log.1 can be neither removed nor renamed over.
+	if err := os.MkdirAll(filepath.Join(path+".1", "blocker"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Write([]byte("aaaaaaaa\n")); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := r.Write([]byte("bbbbbbbb\n")); err == nil || n != 9 {
+		t.Fatalf("write during failed rotation = (%d, %v), want (9, error)", n, err)
+	}
+	if _, err := r.Write([]byte("c\n")); err != nil {
+		t.Fatalf("write after failed rotation: %v", err)
+	}
+	if got := readFile(t, path); got != "aaaaaaaa\nbbbbbbbb\nc\n" {
+		t.Fatalf("bot.log = %q, want all records appended", got)
+	}
+
+	if err := os.RemoveAll(path + ".1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Write([]byte("dddddddd\n")); err != nil {
+		t.Fatalf("write after recovery: %v", err)
+	}
+	if got := readFile(t, path+".1"); got != "aaaaaaaa\nbbbbbbbb\nc\n" {
+		t.Errorf("bot.log.1 = %q", got)
+	}
+	if got := readFile(t, path); got != "dddddddd\n" {
+		t.Errorf("bot.log = %q", got)
+	}
+	// The back-off is cleared once rotation works again.
+	if _, err := r.Write([]byte("eeeeeeee\n")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, path+".1"); got != "dddddddd\n" {
+		t.Errorf("bot.log.1 after the next rotation = %q", got)
+	}
+}
+
+func TestRotatingFileClosed(t *testing.T) {
+	r, err := NewRotatingFile(filepath.Join(t.TempDir(), "bot.log"), 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Write([]byte("x")); !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("write after Close err = %v, want os.ErrClosed", err)
+	}
+}
@@ -0,0 +1,156 @@
+package logging
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/Hafuunano/Core-SkillAction/types"
+	"github.com/Hafuunano/Protocol-ConvertTool/protocol"
+)
+
+// trace collects what happened to one event while the chain runs.
+type trace struct {
+	mu        sync.Mutex
+	replies   int      // messages sent by any handler
+	senders   []string // named handlers that sent a message, each name once
+	running   string   // named handler currently running, for BlockNext, sends and panic attribution
+	blockedBy string
+	blocked   bool
+}
+
+func (t *trace) enter(name string) (leave func()) {
+	t.mu.Lock()
+	prev := t.running
+	t.running = name
+	t.mu.Unlock()
+	return func() {
+		t.mu.Lock()
+		t.running = prev
+		t.mu.Unlock()
+	}
+}
+
+func (t *trace) block() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.blocked {
+		t.blocked, t.blockedBy = true, t.running
+	}
+}
+
+func (t *trace) sent() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.replies++
+	if t.running != "" && !slices.Contains(t.senders, t.running) {
+		t.senders = append(t.senders, t.running)
+	}
+}
+
+func (t *trace) current() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.running
+}
+
+func (t *trace) snapshot() (replies int, senders []string, blockedBy string, blocked bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.replies, slices.Clone(t.senders), t.blockedBy, t.blocked
+}
+
+// tracedContext is the Context the chain sees under New: it records BlockNext and sends.
+// Unwrap returns the host's Context, so optional interfaces (e.g. whitelist.RequestContext) stay reachable.
+type tracedContext struct {
+	protocol.Context
+	trace *trace
+}
+
+func newTracedContext(ctx protocol.Context) *tracedContext {
+	return &tracedContext{Context: ctx, trace: &trace{}}
+}
+
+// BlockNext implements protocol.Context, recording which handler stopped the chain.
+func (c *tracedContext) BlockNext() {
+	c.trace.block()
+	c.Context.BlockNext()
+}
+
+// Send implements protocol.Context, recording the running handler as a sender. The other send methods do the same.
+func (c *tracedContext) Send(msg protocol.Message) error {
+	c.trace.sent()
+	return c.Context.Send(msg)
+}
+
+func (c *tracedContext) Reply(msg protocol.Message) error {
+	c.trace.sent()
+	return c.Context.Reply(msg)
+}
+
+func (c *tracedContext) SendWithReply(msg protocol.Message) error {
+	c.trace.sent()
+	return c.Context.SendWithReply(msg)
+}
+
+func (c *tracedContext) SendPlainMessage(text string) error {
+	c.trace.sent()
+	return c.Context.SendPlainMessage(text)
+}
+
+func (c *tracedContext) SendWithImage(file string) error {
+	c.trace.sent()
+	return c.Context.SendWithImage(file)
+}
+
+func (c *tracedContext) SendWithImageAndText(file string, text string) error {
+	c.trace.sent()
+	return c.Context.SendWithImageAndText(file, text)
+}
+
+func (c *tracedContext) SendPoke(targetUserID string) error {
+	c.trace.sent()
+	return c.Context.SendPoke(targetUserID)
+}
+
+// Unwrap returns the wrapped Context.
+func (c *tracedContext) Unwrap() protocol.Context {
+	return c.Context
+}
+
+// traceOf finds the event trace in ctx, looking through contexts that wrap it.
+func traceOf(ctx protocol.Context) *trace {
+	for ctx != nil {
+		if tc, ok := ctx.(*tracedContext); ok {
+			return tc.trace
+		}
+		u, ok := ctx.(interface{ Unwrap() protocol.Context })
+		if !ok {
+			return nil
+		}
+		ctx = u.Unwrap()
+	}
+	return nil
+}
+
+// Named attributes h's sends, BlockNext and panics to name in the event log entry. Without New in the chain it just calls h.
+func Named(name string, h protocol.Handler) protocol.Handler {
+	return func(ctx protocol.Context) {
+		t := traceOf(ctx)
+		if t == nil {
+			h(ctx)
+			return
+		}
+		leave := t.enter(name)
+		// On panic, keep name as the running handler so New can attribute the panic to it.
+		h(ctx)
+		leave()
+	}
+}
+
+// ForPlugin returns a protocol.Middleware naming the wrapped handler after meta.PluginName,
+// e.g. p.OnMessage().Func(logging.ForPlugin(Meta)(handler)).
+func ForPlugin(meta types.PluginEngine) protocol.Middleware {
+	return func(next protocol.Handler) protocol.Handler {
+		return Named(meta.PluginName, next)
+	}
+}
@@ -0,0 +1,299 @@
+// Package ratelimit provides a token-bucket rate limiter middleware: per user, per group and global buckets,
+// optionally configured per plugin. Inject globally, e.g. zerobot.InstallWithMiddlewares([]protocol.Middleware{ratelimit.New(ratelimit.DefaultConfig())}),
+// or per plugin with a shared Limiter: p.OnMessage().Func(limiter.ForPlugin(Meta)(handler)). Super admins are exempt by default.
+// Only messages addressed to the bot (commands, @bot or replies to it, private chat) are counted by default, so ordinary
+// group chat neither spends tokens nor gets throttled; Config.Counted changes that. A message seen by both the default and
+// the @-bot reply chain is charged once. Dropped messages get an optional "slow down" reply, itself limited to one per
+// ReplyInterval per user and chat.
+package ratelimit
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Hafuunano/Core-SkillAction/types"
+	"github.com/Hafuunano/Protocol-ConvertTool/protocol"
+)
+
+const (
+	// idleSweepInterval is how often buckets that have refilled completely are dropped.
+	idleSweepInterval = time.Minute
+	globalScopeKey    = "global"
+	// seenTTL is how long a message's decision is kept, so the same message through a second chain is not charged again.
+	seenTTL = time.Minute
+)
+
+// Meta is this middleware's metadata for config (MiddlewareName etc.).
+var Meta = types.MiddlewareEngine{
+	MiddlewareID:   "middleware-ratelimit",
+	MiddlewareName: "ratelimit",
+}
+
+// Limit is one token bucket: Burst tokens at most, refilled at Rate tokens per second. Burst <= 0 means unlimited.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Config holds the limits of one scope (the whole chain, or one plugin).
+type Config struct {
+	User   Limit // per user, across chats
+	Group  Limit // per group; private chats have no group bucket
+	Global Limit // all users and groups together
+
+	SlowDownReply    string        // sent when a message is dropped; "" sends nothing
+	ReplyInterval    time.Duration // at most one SlowDownReply per user and chat in this period
+	ExemptSuperAdmin bool
+
+	// Counted selects the messages that spend tokens; the others pass untouched. Nil means Addressed.
+	// Use AllMessages to count everything, e.g. for a plugin handler that only runs on its own commands.
+	Counted func(ctx protocol.Context) bool
+}
+
+// Addressed reports whether ctx is meant for the bot: private chat, @bot or a reply to it, or a command.
+func Addressed(ctx protocol.Context) bool {
+	if gid := ctx.GroupID(); gid == "" || gid == "0" || ctx.IsOnlyToMe() {
+		return true
+	}
+	prefix := ctx.CommandPrefix()
+	return prefix != "" && strings.HasPrefix(strings.TrimSpace(ctx.PlainText()), prefix)
+}
+
+// AllMessages counts every message.
+func AllMessages(protocol.Context) bool {
+	return true
+}
+
+// DefaultConfig returns moderate limits for bot-addressed messages: a user 5 then 1 per 5s, a group 20 then 1 per second,
+// 50 then 5 per second overall.
+func DefaultConfig() Config {
+	return Config{
+		User:             Limit{Rate: 0.2, Burst: 5},
+		Group:            Limit{Rate: 1, Burst: 20},
+		Global:           Limit{Rate: 5, Burst: 50},
+		SlowDownReply:    "说话太快啦，请稍后再试",
+		ReplyInterval:    30 * time.Second,
+		ExemptSuperAdmin: true,
+	}
+}
+
+// Clock is the limiter's time source. Tests can pass a fake to NewLimiter.
+type Clock interface {
+	Now() time.Time
+}
+
+type wallClock struct{}
+
+func (wallClock) Now() time.Time { return time.Now() }
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	limit  Limit
+}
+
+// Limiter holds the buckets of every scope. Safe for concurrent use.
+type Limiter struct {
+	clock Clock
+
+	mu        sync.Mutex
+	cfg       Config
+	plugins   map[string]Config // PluginName -> config, see SetPluginConfig
+	buckets   map[string]*bucket
+	lastReply map[string]time.Time
+	seen      map[string]seenMessage // scope|message ID -> decision already taken for it
+	lastSweep time.Time
+}
+
+type seenMessage struct {
+	allowed bool
+	at      time.Time
+}
+
+// NewLimiter returns a Limiter using cfg for the whole chain and for plugins without their own config. A nil clock means the wall clock.
+func NewLimiter(cfg Config, clock Clock) *Limiter {
+	if clock == nil {
+		clock = wallClock{}
+	}
+	return &Limiter{
+		clock:     clock,
+		cfg:       cfg,
+		plugins:   make(map[string]Config),
+		buckets:   make(map[string]*bucket),
+		lastReply: make(map[string]time.Time),
+		seen:      make(map[string]seenMessage),
+		lastSweep: clock.Now(),
+	}
+}
+
+// New returns a protocol.Middleware limiting the whole chain with cfg.
+func New(cfg Config) protocol.Middleware {
+	return NewLimiter(cfg, nil).Middleware()
+}
+
+// SetPluginConfig gives pluginName (types.PluginEngine.PluginName) its own limits and buckets.
+func (l *Limiter) SetPluginConfig(pluginName string, cfg Config) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.plugins[pluginName] = cfg
+}
+
+// Middleware limits the whole chain with the Limiter's default config.
+func (l *Limiter) Middleware() protocol.Middleware {
+	return func(next protocol.Handler) protocol.Handler {
+		return l.wrap("", next)
+	}
+}
+
+// ForPlugin limits one plugin's handler with its SetPluginConfig config, or the default one, in buckets of its own.
+func (l *Limiter) ForPlugin(meta types.PluginEngine) protocol.Middleware {
+	return func(next protocol.Handler) protocol.Handler {
+		return l.wrap(meta.PluginName, next)
+	}
+}
+
+func (l *Limiter) wrap(plugin string, next protocol.Handler) protocol.Handler {
+	return func(ctx protocol.Context) {
+		counted := l.config(plugin).Counted
+		if counted == nil {
+			counted = Addressed
+		}
+		if !counted(ctx) {
+			next(ctx)
+			return
+		}
+		// Host callbacks run before take, outside the limiter-wide lock.
+		allowed, reply := l.take(plugin, ctx.UserID(), ctx.GroupID(), ctx.MessageID(), ctx.IsSuperAdmin())
+		if allowed {
+			next(ctx)
+			return
+		}
+		if reply != "" {
+			_ = ctx.SendPlainMessage(reply)
+		}
+	}
+}
+
+// Allow takes one token for userID in groupID ("" or "0" for private chat) under plugin's config ("" for the chain).
+// It reports whether the message may pass; super admin exemption is not applied here.
+func (l *Limiter) Allow(plugin, userID, groupID string) bool {
+	allowed, _ := l.take(plugin, userID, groupID, "", false)
+	return allowed
+}
+
+// config returns plugin's config, or the default one.
+func (l *Limiter) config(plugin string) Config {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.configLocked(plugin)
+}
+
+func (l *Limiter) configLocked(plugin string) Config {
+	if c, ok := l.plugins[plugin]; ok && plugin != "" {
+		return c
+	}
+	return l.cfg
+}
+
+// take consumes a token from every applicable bucket, or from none when one of them is empty.
+// A message ID already decided in this scope gets the same answer without spending again ("" disables this).
+// When dropped it returns the slow-down reply to send, if one is due.
+func (l *Limiter) take(plugin, userID, groupID, messageID string, superAdmin bool) (allowed bool, reply string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	cfg := l.configLocked(plugin)
+	if cfg.ExemptSuperAdmin && superAdmin {
+		return true, ""
+	}
+	now := l.clock.Now()
+	l.sweep(now)
+	scope := plugin + "|"
+	if messageID != "" {
+		key := scope + messageID
+		if s, ok := l.seen[key]; ok && now.Sub(s.at) < seenTTL {
+			return s.allowed, ""
+		}
+		defer func() { l.seen[key] = seenMessage{allowed: allowed, at: now} }()
+	}
+	type hold struct {
+		b     *bucket
+		limit Limit
+	}
+	holds := make([]hold, 0, 3)
+	add := func(key string, limit Limit) {
+		if limit.Burst > 0 {
+			holds = append(holds, hold{l.refill(scope+key, limit, now), limit})
+		}
+	}
+	add("u:"+userID, cfg.User)
+	if groupID != "" && groupID != "0" {
+		add("g:"+groupID, cfg.Group)
+	}
+	add(globalScopeKey, cfg.Global)
+	for _, h := range holds {
+		if h.b.tokens < 1 {
+			return false, l.slowDownReply(cfg, scope+userID+"@"+groupID, now)
+		}
+	}
+	for _, h := range holds {
+		h.b.tokens--
+	}
+	return true, ""
+}
+
+// refill returns the bucket at key topped up for the time elapsed since its last use. Caller holds mu.
+func (l *Limiter) refill(key string, limit Limit, now time.Time) *bucket {
+	b := l.buckets[key]
+	if b == nil {
+		b = &bucket{tokens: float64(limit.Burst), last: now, limit: limit}
+		l.buckets[key] = b
+		return b
+	}
+	b.limit = limit
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = min(float64(limit.Burst), b.tokens+elapsed*limit.Rate)
+	}
+	b.last = now
+	return b
+}
+
+// slowDownReply returns cfg.SlowDownReply when none was sent to key within ReplyInterval. Caller holds mu.
+func (l *Limiter) slowDownReply(cfg Config, key string, now time.Time) string {
+	if cfg.SlowDownReply == "" {
+		return ""
+	}
+	if last, ok := l.lastReply[key]; ok && now.Sub(last) < cfg.ReplyInterval {
+		return ""
+	}
+	l.lastReply[key] = now
+	return cfg.SlowDownReply
+}
+
+// sweep drops state that no longer matters, so the maps only hold recently active users and groups. Caller holds mu.
+// A bucket that would have refilled completely by now is dropped; recreating it later starts full, which is the same.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < idleSweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if b.limit.Rate > 0 && b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate >= float64(b.limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+	maxInterval := l.cfg.ReplyInterval
+	for _, c := range l.plugins {
+		maxInterval = max(maxInterval, c.ReplyInterval)
+	}
+	for key, t := range l.lastReply {
+		if now.Sub(t) >= maxInterval {
+			delete(l.lastReply, key)
+		}
+	}
+	for key, s := range l.seen {
+		if now.Sub(s.at) >= seenTTL {
+			delete(l.seen, key)
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package ratelimit
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Hafuunano/Core-SkillAction/types"
+	"github.com/Hafuunano/Protocol-ConvertTool/protocol"
+)
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// fakeContext implements the parts of protocol.Context the limiter reads; calling anything else panics.
+type fakeContext struct {
+	protocol.Context
+	user, group, text, msgID string
+	toMe, superAdmin         bool
+	sent                     []string
+}
+
+func (c *fakeContext) UserID() string        { return c.user }
+func (c *fakeContext) GroupID() string       { return c.group }
+func (c *fakeContext) PlainText() string     { return c.text }
+func (c *fakeContext) MessageID() string     { return c.msgID }
+func (c *fakeContext) IsOnlyToMe() bool      { return c.toMe }
+func (c *fakeContext) IsSuperAdmin() bool    { return c.superAdmin }
+func (c *fakeContext) CommandPrefix() string { return "/" }
+func (c *fakeContext) SendPlainMessage(text string) error {
+	c.sent = append(c.sent, text)
+	return nil
+}
+
+var msgSeq int
+
+// command returns a new bot command from user in group, with its own message ID.
+func command(user, group string) *fakeContext {
+	msgSeq++
+	return &fakeContext{user: user, group: group, text: "/ping", msgID: strconv.Itoa(msgSeq)}
+}
+
+// run passes ctx through mw and reports whether the handler ran.
+func run(mw protocol.Middleware, ctx protocol.Context) bool {
+	ran := false
+	mw(func(protocol.Context) { ran = true })(ctx)
+	return ran
+}
+
+func userOnly(rate float64, burst int) Config {
+	return Config{User: Limit{Rate: rate, Burst: burst}}
+}
+
+func TestUserBurstAndRefill(t *testing.T) {
+	clock := newFakeClock()
+	l := NewLimiter(userOnly(0.5, 3), clock)
+	for i := 0; i < 3; i++ {
+		if !l.Allow("", "u1", "g1") {
+			t.Fatalf("message %d within burst dropped", i+1)
+		}
+	}
+	if l.Allow("", "u1", "g1") {
+		t.Fatal("message beyond burst passed")
+	}
+	if !l.Allow("", "u2", "g1") {
+		t.Fatal("another user shares u1's bucket")
+	}
+	clock.Advance(1999 * time.Millisecond)
+	if l.Allow("", "u1", "g1") {
+		t.Fatal("token refilled too early")
+	}
+	clock.Advance(time.Millisecond)
+	if !l.Allow("", "u1", "g1") {
+		t.Fatal("token not refilled after 1/rate")
+	}
+	clock.Advance(time.Hour)
+	for i := 0; i < 3; i++ {
+		if !l.Allow("", "u1", "g1") {
+			t.Fatalf("refill exceeded burst or stopped short: message %d dropped", i+1)
+		}
+	}
+	if l.Allow("", "u1", "g1") {
+		t.Fatal("bucket refilled beyond burst")
+	}
+}
+
+func TestGroupAndGlobalBuckets(t *testing.T) {
+	clock := newFakeClock()
+	l := NewLimiter(Config{Group: Limit{Rate: 1, Burst: 2}, Global: Limit{Rate: 1, Burst: 3}}, clock)
+	if !l.Allow("", "u1", "g1") || !l.Allow("", "u2", "g1") {
+		t.Fatal("group burst dropped")
+	}
+	if l.Allow("", "u3", "g1") {
+		t.Fatal("group bucket not shared by its members")
+	}
+	if !l.Allow("", "u3", "") {
+		t.Fatal("private chat charged to a group bucket")
+	}
+	if l.Allow("", "u4", "g2") {
+		t.Fatal("global bucket not shared across groups")
+	}
+}
+
+// TestDroppedMessageSpendsNothing checks an empty bucket leaves the others untouched.
+func TestDroppedMessageSpendsNothing(t *testing.T) {
+	clock := newFakeClock()
+	l := NewLimiter(Config{User: Limit{Rate: 1, Burst: 1}, Group: Limit{Rate: 1, Burst: 2}}, clock)
+	l.Allow("", "u1", "g1")
+	for i := 0; i < 5; i++ {
+		l.Allow("", "u1", "g1") // dropped by u1's bucket
+	}
+	if !l.Allow("", "u2", "g1") {
+		t.Fatal("dropped messages spent group tokens")
+	}
+}
+
+func TestMiddlewareCountsOnlyAddressedMessages(t *testing.T) {
+	clock := newFakeClock()
+	mw := NewLimiter(userOnly(0.01, 1), clock).Middleware()
+	for i := 0; i < 10; i++ {
+		chat := &fakeContext{user: "u1", group: "g1", text: "今天吃什么", msgID: "c" + strconv.Itoa(i)}
+		if !run(mw, chat) {
+			t.Fatal("ordinary group chat was limited")
+		}
+	}
+	if !run(mw, command("u1", "g1")) {
+		t.Fatal("first command dropped after plain chat")
+	}
+	if run(mw, command("u1", "g1")) {
+		t.Fatal("second command passed the burst")
+	}
+	if run(mw, &fakeContext{user: "u1", group: "g1", text: "在吗", msgID: "m1", toMe: true}) {
+		t.Fatal("@bot message not counted")
+	}
+	if run(mw, &fakeContext{user: "u1", text: "hi", msgID: "m2"}) {
+		t.Fatal("private message not counted")
+	}
+
+	all := NewLimiter(Config{User: Limit{Rate: 0.01, Burst: 1}, Counted: AllMessages}, clock).Middleware()
+	run(all, &fakeContext{user: "u1", group: "g1", text: "a", msgID: "a1"})
+	if run(all, &fakeContext{user: "u1", group: "g1", text: "b", msgID: "a2"}) {
+		t.Fatal("AllMessages did not count plain chat")
+	}
+}
+
+// TestSameMessageChargedOnce runs one message through the default and the reply chain, as the host does for @bot.
+func TestSameMessageChargedOnce(t *testing.T) {
+	clock := newFakeClock()
+	mw := NewLimiter(userOnly(0.01, 2), clock).Middleware()
+	first := command("u1", "g1")
+	again := *first
+	again.toMe = true
+	if !run(mw, first) || !run(mw, &again) {
+		t.Fatal("message dropped within burst")
+	}
+	if !run(mw, command("u1", "g1")) {
+		t.Fatal("one message was charged twice")
+	}
+	if run(mw, command("u1", "g1")) {
+		t.Fatal("burst exceeded")
+	}
+	dropped := command("u1", "g1")
+	run(mw, dropped)
+	again = *dropped
+	again.sent = nil
+	if run(mw, &again) {
+		t.Fatal("dropped message passed the second chain")
+	}
+	if len(again.sent) != 0 {
+		t.Fatal("second chain repeated the slow-down reply")
+	}
+}
+
+func TestSlowDownReplyInterval(t *testing.T) {
+	clock := newFakeClock()
+	cfg := userOnly(0.01, 1)
+	cfg.SlowDownReply, cfg.ReplyInterval = "slow", 30*time.Second
+	mw := NewLimiter(cfg, clock).Middleware()
+	run(mw, command("u1", "g1"))
+	var replies int
+	for i := 0; i < 5; i++ {
+		ctx := command("u1", "g1")
+		run(mw, ctx)
+		replies += len(ctx.sent)
+	}
+	if replies != 1 {
+		t.Fatalf("%d slow-down replies within the interval, want 1", replies)
+	}
+	clock.Advance(30 * time.Second)
+	ctx := command("u1", "g1")
+	if run(mw, ctx) || len(ctx.sent) != 1 {
+		t.Fatalf("after the interval: sent %v, want one reply", ctx.sent)
+	}
+	other := command("u1", "g2")
+	run(mw, other)
+	if len(other.sent) != 1 {
+		t.Fatal("reply interval not kept per chat")
+	}
+}
+
+func TestSuperAdminExempt(t *testing.T) {
+	clock := newFakeClock()
+	cfg := userOnly(0.01, 1)
+	cfg.ExemptSuperAdmin = true
+	mw := NewLimiter(cfg, clock).Middleware()
+	for i := 0; i < 5; i++ {
+		ctx := command("admin", "g1")
+		ctx.superAdmin = true
+		if !run(mw, ctx) {
+			t.Fatal("super admin limited")
+		}
+	}
+	if !run(mw, command("admin", "g1")) {
+		t.Fatal("exempt messages spent tokens")
+	}
+}
+
+func TestPluginConfigHasOwnBuckets(t *testing.T) {
+	clock := newFakeClock()
+	l := NewLimiter(userOnly(0.01, 1), clock)
+	l.SetPluginConfig("agent", Config{User: Limit{Rate: 0.01, Burst: 2}, Counted: AllMessages})
+	chain, agent := l.Middleware(), l.ForPlugin(types.PluginEngine{PluginName: "agent"})
+	if !run(chain, command("u1", "g1")) {
+		t.Fatal("chain burst dropped")
+	}
+	for i := 0; i < 2; i++ {
+		if !run(agent, &fakeContext{user: "u1", group: "g1", text: "hi", msgID: "p" + strconv.Itoa(i)}) {
+			t.Fatal("plugin bucket shared with the chain")
+		}
+	}
+	if run(agent, &fakeContext{user: "u1", group: "g1", text: "hi", msgID: "p2"}) {
+		t.Fatal("plugin burst exceeded")
+	}
+}
+
+func TestSweepDropsRefilledState(t *testing.T) {
+	clock := newFakeClock()
+	l := NewLimiter(userOnly(1, 2), clock)
+	for i := 0; i < 100; i++ {
+		l.Allow("", strconv.Itoa(i), "")
+	}
+	clock.Advance(idleSweepInterval)
+	l.Allow("", "x", "")
+	l.mu.Lock()
+	n := len(l.buckets)
+	l.mu.Unlock()
+	if n != 1 {
+		t.Fatalf("%d buckets after sweep, want 1", n)
+	}
+}
@@ -0,0 +1,303 @@
+package whitelist
+
+import (
+	"encoding/json"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Hafuunano/Core-SkillAction/cache/database"
+	"github.com/Hafuunano/Core-SkillAction/types"
+	"github.com/Hafuunano/Protocol-ConvertTool/protocol"
+)
+
+const (
+	keyPrefixACL  = "whitelist:acl:" // + PluginName
+	cmdGrant      = "grantPlugin"
+	cmdRevoke     = "revokePlugin"
+	cmdResetACL   = "resetPluginACL"
+	cmdListACL    = "listPluginACL"
+	aclScopeGroup = "group"
+	aclScopeUser  = "user"
+)
+
+// PluginACL is the access list of one plugin, stored as JSON at whitelist:acl:{PluginName}.
+// Deny entries win; a user in AllowUsers passes anywhere; otherwise in groups the group must be in AllowGroups,
+// or the groups are unrestricted and the plugin is on by default (PluginIsDefaultOn). The first grant restricts
+// its scope, and it stays restricted when revokes empty the allow list: only resetPluginACL opens it again.
+type PluginACL struct {
+	AllowGroups    []string `json:"allowGroups,omitempty"`
+	DenyGroups     []string `json:"denyGroups,omitempty"`
+	AllowUsers     []string `json:"allowUsers,omitempty"`
+	DenyUsers      []string `json:"denyUsers,omitempty"`
+	RestrictGroups bool     `json:"restrictGroups,omitempty"` // groups need AllowGroups even when it is empty
+	RestrictUsers  bool     `json:"restrictUsers,omitempty"`  // private chat needs AllowUsers even when it is empty
+}
+
+// groupsRestricted reports whether groups must be in AllowGroups (ACLs saved before the flags count a non-empty list).
+func (a PluginACL) groupsRestricted() bool {
+	return a.RestrictGroups || len(a.AllowGroups) > 0
+}
+
+// usersRestricted reports whether private chat is limited to AllowUsers.
+func (a PluginACL) usersRestricted() bool {
+	return a.RestrictUsers || len(a.AllowUsers) > 0
+}
+
+var (
+	pluginsMu sync.RWMutex
+	plugins   = make(map[string]types.PluginEngine) // PluginName -> Meta, filled by PluginMiddleware
+)
+
+// PluginMiddleware returns a protocol.Middleware enforcing the ACL of the plugin described by meta, using the store set by SetStore.
+// Super admin always passes. Use when registering the plugin, e.g. p.OnMessage().Func(whitelist.PluginMiddleware(Meta)(handler)).
+func PluginMiddleware(meta types.PluginEngine) protocol.Middleware {
+	pluginsMu.Lock()
+	plugins[meta.PluginName] = meta
+	pluginsMu.Unlock()
+	return func(next protocol.Handler) protocol.Handler {
+		return func(ctx protocol.Context) {
+			storeMu.RLock()
+			s := store
+			storeMu.RUnlock()
+			if ctx.IsSuperAdmin() || s == nil || PluginAllowed(s, meta, ctx.GroupID(), ctx.UserID()) {
+				next(ctx)
+			}
+		}
+	}
+}
+
+// PluginAllowed reports whether meta's plugin may run for userID in groupID ("" or "0" for private chat).
+func PluginAllowed(store *database.Store, meta types.PluginEngine, groupID, userID string) bool {
+	acl := LoadPluginACL(store, meta.PluginName)
+	isPrivate := groupID == "" || groupID == "0"
+	if slices.Contains(acl.DenyUsers, userID) || (!isPrivate && slices.Contains(acl.DenyGroups, groupID)) {
+		return false
+	}
+	if slices.Contains(acl.AllowUsers, userID) {
+		return true
+	}
+	if isPrivate {
+		return !acl.usersRestricted() && meta.PluginIsDefaultOn
+	}
+	if acl.groupsRestricted() {
+		return slices.Contains(acl.AllowGroups, groupID)
+	}
+	return meta.PluginIsDefaultOn
+}
+
+// LoadPluginACL returns the stored ACL of pluginName; the zero value when none is set.
+func LoadPluginACL(store *database.Store, pluginName string) PluginACL {
+	var acl PluginACL
+	if store == nil || pluginName == "" {
+		return acl
+	}
+	raw, found := lookup(store, keyPrefixACL+pluginName)
+	if !found || raw == "" {
+		return acl
+	}
+	if json.Unmarshal([]byte(raw), &acl) != nil {
+		return PluginACL{}
+	}
+	return acl
+}
+
+// SavePluginACL stores acl for pluginName; an empty ACL deletes the key. It is not audited; prefer GrantPlugin, RevokePlugin and ResetPluginACL.
+func SavePluginACL(store *database.Store, pluginName string, acl PluginACL) error {
+	if store == nil || pluginName == "" {
+		return nil
+	}
+	key := keyPrefixACL + pluginName
+	if len(acl.AllowGroups)+len(acl.DenyGroups)+len(acl.AllowUsers)+len(acl.DenyUsers) == 0 && !acl.RestrictGroups && !acl.RestrictUsers {
+		return del(store, key)
+	}
+	raw, err := json.Marshal(acl)
+	if err != nil {
+		return err
+	}
+	return set(store, key, string(raw))
+}
+
+// GrantPlugin lets id (a group or user, per scope) use pluginName: it joins the allow list and leaves the deny list.
+// operator is recorded in the audit log.
+func GrantPlugin(store *database.Store, pluginName, scope, id, operator string) error {
+	acl := LoadPluginACL(store, pluginName)
+	allow, deny := acl.lists(scope)
+	*allow = addID(*allow, id)
+	*deny = removeID(*deny, id)
+	acl.restrict(scope)
+	if err := SavePluginACL(store, pluginName, acl); err != nil {
+		return err
+	}
+	audit(store, operator, auditGrantPlugin, scope+":"+id, pluginName)
+	return nil
+}
+
+// RevokePlugin takes pluginName away from id: it leaves the allow list if it was there, otherwise it joins the deny list.
+// The scope stays restricted, so revoking the last allowed group (or user) leaves nobody allowed rather than everybody.
+func RevokePlugin(store *database.Store, pluginName, scope, id, operator string) error {
+	acl := LoadPluginACL(store, pluginName)
+	allow, deny := acl.lists(scope)
+	if slices.Contains(*allow, id) {
+		*allow = removeID(*allow, id)
+		acl.restrict(scope)
+	} else {
+		*deny = addID(*deny, id)
+	}
+	if err := SavePluginACL(store, pluginName, acl); err != nil {
+		return err
+	}
+	audit(store, operator, auditRevokePlugin, scope+":"+id, pluginName)
+	return nil
+}
+
+// ResetPluginACL clears every grant and revocation of pluginName.
+func ResetPluginACL(store *database.Store, pluginName, operator string) error {
+	if err := SavePluginACL(store, pluginName, PluginACL{}); err != nil {
+		return err
+	}
+	audit(store, operator, auditResetACL, pluginName, "")
+	return nil
+}
+
+func (a *PluginACL) lists(scope string) (allow, deny *[]string) {
+	if scope == aclScopeUser {
+		return &a.AllowUsers, &a.DenyUsers
+	}
+	return &a.AllowGroups, &a.DenyGroups
+}
+
+// restrict marks scope as limited to its allow list.
+func (a *PluginACL) restrict(scope string) {
+	if scope == aclScopeUser {
+		a.RestrictUsers = true
+	} else {
+		a.RestrictGroups = true
+	}
+}
+
+func addID(ids []string, id string) []string {
+	if slices.Contains(ids, id) {
+		return ids
+	}
+	ids = append(ids, id)
+	sort.Strings(ids)
+	return ids
+}
+
+func removeID(ids []string, id string) []string {
+	return slices.DeleteFunc(ids, func(v string) bool { return v == id })
+}
+
+// handleACLCommands handles grantPlugin, revokePlugin, resetPluginACL and listPluginACL (super admin only).
+func handleACLCommands(ctx protocol.Context) {
+	parts := strings.Fields(strings.TrimSpace(ctx.PlainText()))
+	if len(parts) == 0 {
+		return
+	}
+	prefix := ctx.CommandPrefix()
+	cmd, ok := strings.CutPrefix(parts[0], prefix)
+	if !ok || (cmd != cmdGrant && cmd != cmdRevoke && cmd != cmdResetACL && cmd != cmdListACL) {
+		return
+	}
+	storeMu.RLock()
+	s := store
+	storeMu.RUnlock()
+	if s == nil {
+		_ = ctx.SendPlainMessage("whitelist store 未初始化")
+		return
+	}
+	switch cmd {
+	case cmdGrant, cmdRevoke:
+		// {prefix}grantPlugin plugin group|user id
+		if len(parts) < 4 || (parts[2] != aclScopeGroup && parts[2] != aclScopeUser) {
+			_ = ctx.SendPlainMessage("用法: " + prefix + cmd + " <插件名> <group|user> <群号|用户ID>")
+			return
+		}
+		name, scope, id := parts[1], parts[2], parts[3]
+		op, verb := GrantPlugin, "允许"
+		if cmd == cmdRevoke {
+			op, verb = RevokePlugin, "禁止"
+		}
+		if err := op(s, name, scope, id, ctx.UserID()); err != nil {
+			_ = ctx.SendPlainMessage("保存插件权限失败")
+			return
+		}
+		_ = ctx.SendPlainMessage("已" + verb + scopeLabel(scope) + id + " 使用 " + name + "\n" + formatPluginPolicy(s, name))
+	case cmdResetACL:
+		if len(parts) < 2 {
+			_ = ctx.SendPlainMessage("用法: " + prefix + cmdResetACL + " <插件名>")
+			return
+		}
+		_ = ResetPluginACL(s, parts[1], ctx.UserID())
+		_ = ctx.SendPlainMessage("已清除 " + parts[1] + " 的插件权限\n" + formatPluginPolicy(s, parts[1]))
+	case cmdListACL:
+		if len(parts) > 1 {
+			_ = ctx.SendPlainMessage(formatPluginPolicy(s, parts[1]))
+			return
+		}
+		pluginsMu.RLock()
+		names := make([]string, 0, len(plugins))
+		for name := range plugins {
+			names = append(names, name)
+		}
+		pluginsMu.RUnlock()
+		if len(names) == 0 {
+			_ = ctx.SendPlainMessage("没有插件使用插件权限中间件")
+			return
+		}
+		sort.Strings(names)
+		blocks := make([]string, 0, len(names))
+		for _, name := range names {
+			blocks = append(blocks, formatPluginPolicy(s, name))
+		}
+		_ = ctx.SendPlainMessage(strings.Join(blocks, "\n\n"))
+	}
+}
+
+func scopeLabel(scope string) string {
+	if scope == aclScopeUser {
+		return "用户 "
+	}
+	return "群 "
+}
+
+// formatPluginPolicy describes the effective policy of pluginName in plain words.
+func formatPluginPolicy(s *database.Store, pluginName string) string {
+	pluginsMu.RLock()
+	meta, known := plugins[pluginName]
+	pluginsMu.RUnlock()
+	acl := LoadPluginACL(s, pluginName)
+	lines := []string{"【" + pluginName + "】"}
+	if !known {
+		lines[0] += "（未使用插件权限中间件，设置暂不生效）"
+	}
+	switch {
+	case len(acl.AllowGroups) > 0:
+		lines = append(lines, "群聊：仅 "+strings.Join(acl.AllowGroups, "、"))
+	case acl.groupsRestricted():
+		lines = append(lines, "群聊：已全部撤销（恢复默认请用 resetPluginACL）")
+	case !known || meta.PluginIsDefaultOn:
+		lines = append(lines, "群聊：所有群")
+	default:
+		lines = append(lines, "群聊：默认关闭")
+	}
+	if len(acl.DenyGroups) > 0 {
+		lines = append(lines, "禁用群："+strings.Join(acl.DenyGroups, "、"))
+	}
+	switch {
+	case len(acl.AllowUsers) > 0:
+		lines = append(lines, "私聊：仅 "+strings.Join(acl.AllowUsers, "、")+"（这些用户在任意群也可使用）")
+	case acl.usersRestricted():
+		lines = append(lines, "私聊：已全部撤销（恢复默认请用 resetPluginACL）")
+	case !known || meta.PluginIsDefaultOn:
+		lines = append(lines, "私聊：所有用户")
+	default:
+		lines = append(lines, "私聊：默认关闭")
+	}
+	if len(acl.DenyUsers) > 0 {
+		lines = append(lines, "禁用用户："+strings.Join(acl.DenyUsers, "、"))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,142 @@
+package whitelist
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Hafuunano/Core-SkillAction/cache/database"
+	"github.com/Hafuunano/Protocol-ConvertTool/protocol"
+)
+
+const (
+	keyAudit         = "whitelist:audit"
+	maxAuditEntries  = 500 // oldest dropped first
+	defaultAuditShow = 20
+	maxAuditShow     = 100
+	cmdAudit         = "whitelistAudit"
+	// operatorSystem attributes changes made without an operator (e.g. host tooling passing "").
+	operatorSystem = "system"
+)
+
+// Audit actions.
+const (
+	auditAddGroup      = "add_group"
+	auditRemoveGroup   = "remove_group"
+	auditAddUser       = "add_user"
+	auditRemoveUser    = "remove_user"
+	auditDenyGroup     = "deny_group"
+	auditUndenyGroup   = "undeny_group"
+	auditDenyUser      = "deny_user"
+	auditUndenyUser    = "undeny_user"
+	auditGrantPlugin   = "grant_plugin"
+	auditRevokePlugin  = "revoke_plugin"
+	auditResetACL      = "reset_plugin_acl"
+	auditRejectRequest = "reject_request"
+)
+
+// AuditEntry is one access change, stored in the JSON array at whitelist:audit.
+type AuditEntry struct {
+	Time     string `json:"time"` // RFC3339
+	Operator string `json:"operator"`
+	Action   string `json:"action"`
+	Target   string `json:"target"`
+	Detail   string `json:"detail,omitempty"`
+}
+
+var auditMu sync.Mutex
+
+// AuditLog returns up to n of the most recent entries, newest last; n <= 0 returns all kept entries.
+func AuditLog(store *database.Store, n int) []AuditEntry {
+	if store == nil {
+		return nil
+	}
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	entries := loadAudit(store)
+	if n > 0 && len(entries) > n {
+		entries = entries[len(entries)-n:]
+	}
+	return entries
+}
+
+func loadAudit(store *database.Store) []AuditEntry {
+	raw, found, _ := store.Get(keyAudit)
+	var entries []AuditEntry
+	if !found || json.Unmarshal([]byte(raw), &entries) != nil {
+		return nil
+	}
+	return entries
+}
+
+// audit records one change. Failures are ignored: the change itself already happened.
+func audit(store *database.Store, operator, action, target, detail string) {
+	if operator == "" {
+		operator = operatorSystem
+	}
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	entries := append(loadAudit(store), AuditEntry{
+		Time:     time.Now().Format(time.RFC3339),
+		Operator: operator,
+		Action:   action,
+		Target:   target,
+		Detail:   detail,
+	})
+	if len(entries) > maxAuditEntries {
+		entries = entries[len(entries)-maxAuditEntries:]
+	}
+	raw, err := json.Marshal(entries)
+	if err != nil {
+		return
+	}
+	_ = store.Set(keyAudit, string(raw))
+}
+
+// handleAuditCommand handles whitelistAudit [n] (super admin only).
+func handleAuditCommand(ctx protocol.Context) {
+	parts := strings.Fields(strings.TrimSpace(ctx.PlainText()))
+	prefix := ctx.CommandPrefix()
+	if len(parts) == 0 || parts[0] != prefix+cmdAudit {
+		return
+	}
+	storeMu.RLock()
+	s := store
+	storeMu.RUnlock()
+	if s == nil {
+		_ = ctx.SendPlainMessage("whitelist store 未初始化")
+		return
+	}
+	n := defaultAuditShow
+	if len(parts) > 1 {
+		v, err := strconv.Atoi(parts[1])
+		if err != nil || v <= 0 {
+			_ = ctx.SendPlainMessage("用法: " + prefix + cmdAudit + " [条数]")
+			return
+		}
+		n = min(v, maxAuditShow)
+	}
+	_ = ctx.SendPlainMessage(formatAudit(AuditLog(s, n)))
+}
+
+func formatAudit(entries []AuditEntry) string {
+	if len(entries) == 0 {
+		return "暂无白名单变更记录"
+	}
+	lines := make([]string, 0, len(entries)+1)
+	lines = append(lines, "最近 "+strconv.Itoa(len(entries))+" 条白名单变更：")
+	for _, e := range entries {
+		ts := e.Time
+		if t, err := time.Parse(time.RFC3339, e.Time); err == nil {
+			ts = t.Format("01-02 15:04")
+		}
+		line := ts + " " + e.Operator + " " + e.Action + " " + e.Target
+		if e.Detail != "" {
+			line += "（" + e.Detail + "）"
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
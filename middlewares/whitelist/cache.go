@@ -0,0 +1,138 @@
+package whitelist
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCacheTTL        = 30 * time.Second
+	defaultCacheMaxEntries = 10000
+)
+
+// CacheStats is a snapshot of the lookup cache counters since start (or the last ConfigureCache).
+type CacheStats struct {
+	Hits          uint64 // lookups answered from the cache, positive or negative
+	Misses        uint64 // lookups that went to the store, including expired entries
+	Evictions     uint64 // entries dropped to stay under the size bound
+	Invalidations uint64 // entries dropped because the key was written through this package
+	Size          int
+	MaxEntries    int
+	TTL           time.Duration
+}
+
+// cacheEntry remembers one store read: found=false is a negative entry.
+type cacheEntry struct {
+	key     string
+	value   string
+	found   bool
+	expires time.Time
+}
+
+// lookupCache is a size-bounded LRU of store reads whose entries expire after ttl, so writes made by other
+// processes (or directly to the store) are picked up within ttl. Writes through this package invalidate at once.
+type lookupCache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	order      *list.List // front = most recently used
+	items      map[string]*list.Element
+	gen        uint64 // bumped by every invalidation, so a read racing a write is not cached
+	stats      CacheStats
+	now        func() time.Time
+}
+
+func newLookupCache(ttl time.Duration, maxEntries int) *lookupCache {
+	return &lookupCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		order:      list.New(),
+		items:      make(map[string]*list.Element),
+		now:        time.Now,
+	}
+}
+
+var cache = newLookupCache(defaultCacheTTL, defaultCacheMaxEntries)
+
+// ConfigureCache replaces the lookup cache; ttl <= 0 or maxEntries <= 0 disables caching. Stats restart from zero.
+func ConfigureCache(ttl time.Duration, maxEntries int) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.ttl, cache.maxEntries = ttl, maxEntries
+	cache.order.Init()
+	clear(cache.items)
+	cache.gen++
+	cache.stats = CacheStats{}
+}
+
+// Stats returns the lookup cache counters.
+func Stats() CacheStats {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	st := cache.stats
+	st.Size, st.MaxEntries, st.TTL = cache.order.Len(), cache.maxEntries, cache.ttl
+	return st
+}
+
+// InvalidateCache drops every cached lookup. Call after writing whitelist keys to the store from outside this package.
+func InvalidateCache() {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.stats.Invalidations += uint64(cache.order.Len())
+	cache.order.Init()
+	clear(cache.items)
+	cache.gen++
+}
+
+// get returns the cached read of key, and the generation to pass to put when it has to be read from the store.
+func (c *lookupCache) get(key string) (value string, found, ok bool, gen uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, hit := c.items[key]; hit {
+		e := el.Value.(*cacheEntry)
+		if c.now().Before(e.expires) {
+			c.order.MoveToFront(el)
+			c.stats.Hits++
+			return e.value, e.found, true, c.gen
+		}
+		c.order.Remove(el)
+		delete(c.items, key)
+	}
+	c.stats.Misses++
+	return "", false, false, c.gen
+}
+
+// put caches a store read taken at generation gen, unless an invalidation happened since.
+func (c *lookupCache) put(key, value string, found bool, gen uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ttl <= 0 || c.maxEntries <= 0 || gen != c.gen {
+		return
+	}
+	e := &cacheEntry{key: key, value: value, found: found, expires: c.now().Add(c.ttl)}
+	if el, hit := c.items[key]; hit {
+		el.Value = e
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.order.PushFront(e)
+	for c.order.Len() > c.maxEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheEntry).key)
+		c.stats.Evictions++
+	}
+}
+
+// invalidate drops key (positive or negative) after a write through this package.
+func (c *lookupCache) invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	if el, hit := c.items[key]; hit {
+		c.order.Remove(el)
+		delete(c.items, key)
+		c.stats.Invalidations++
+	}
+}
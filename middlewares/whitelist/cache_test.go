@@ -0,0 +1,149 @@
+package whitelist
+
+import (
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Hafuunano/Core-SkillAction/cache/database"
+	skillcore "github.com/Hafuunano/Core-SkillAction/core"
+)
+
+func newTestStore(t *testing.T) *database.Store {
+	t.Helper()
+	svc, err := skillcore.NewServices(skillcore.ServicesOptions{
+		DBPath:        filepath.Join(t.TempDir(), "whitelist.db"),
+		EnableDBCache: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ConfigureCache(defaultCacheTTL, defaultCacheMaxEntries)
+	t.Cleanup(InvalidateCache)
+	return svc.Cache
+}
+
+// TestCacheAddRemoveRacingHasGroup toggles a group while readers keep filling the cache; once the writers stop,
+// HasGroup must agree with the store at once, not after the TTL.
+func TestCacheAddRemoveRacingHasGroup(t *testing.T) {
+	s := newTestStore(t)
+	const gid = "10001"
+	for round := 0; round < 20; round++ {
+		var wg sync.WaitGroup
+		stop := make(chan struct{})
+		for r := 0; r < 8; r++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					select {
+					case <-stop:
+						return
+					default:
+						HasGroup(s, gid)
+					}
+				}
+			}()
+		}
+		for i := 0; i < 10; i++ {
+			if i%2 == 0 {
+				_ = AddGroup(s, gid, "test")
+			} else {
+				_ = RemoveGroup(s, gid, "test")
+			}
+		}
+		want := round%2 == 0
+		if want {
+			_ = AddGroup(s, gid, "test")
+		} else {
+			_ = RemoveGroup(s, gid, "test")
+		}
+		close(stop)
+		wg.Wait()
+		if got := HasGroup(s, gid); got != want {
+			t.Fatalf("round %d: HasGroup = %v after writers stopped, want %v", round, got, want)
+		}
+	}
+}
+
+// TestCacheWriteVisibleToConcurrentReaders checks every group written by one goroutine is seen by the others
+// right after the write returns, even when they cached a negative read just before.
+func TestCacheWriteVisibleToConcurrentReaders(t *testing.T) {
+	s := newTestStore(t)
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 25; i++ {
+				gid := strconv.Itoa(w*1000 + i)
+				if HasGroup(s, gid) {
+					t.Errorf("group %s listed before AddGroup", gid)
+					return
+				}
+				if err := AddGroup(s, gid, "test"); err != nil {
+					t.Error(err)
+					return
+				}
+				if !HasGroup(s, gid) {
+					t.Errorf("group %s not listed right after AddGroup", gid)
+					return
+				}
+				if err := RemoveGroup(s, gid, "test"); err != nil {
+					t.Error(err)
+					return
+				}
+				if HasGroup(s, gid) {
+					t.Errorf("group %s still listed right after RemoveGroup", gid)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+}
+
+// TestCachePutAfterInvalidateIsDropped is the interleaving the generation check exists for: a reader misses,
+// a writer changes the key, then the reader stores the value it read before the write.
+func TestCachePutAfterInvalidateIsDropped(t *testing.T) {
+	c := newLookupCache(time.Minute, 10)
+	_, _, ok, gen := c.get("k")
+	if ok {
+		t.Fatal("empty cache hit")
+	}
+	c.invalidate("k")
+	c.put("k", "", false, gen)
+	if _, _, ok, _ := c.get("k"); ok {
+		t.Fatal("stale read cached after invalidate")
+	}
+	_, _, _, gen = c.get("k")
+	c.put("k", "1", true, gen)
+	if v, found, ok, _ := c.get("k"); !ok || !found || v != "1" {
+		t.Fatalf("get = %q, %v, %v; want cached \"1\"", v, found, ok)
+	}
+}
+
+func TestCacheTTLAndEviction(t *testing.T) {
+	c := newLookupCache(time.Minute, 2)
+	now := time.Unix(0, 0)
+	c.now = func() time.Time { return now }
+	for _, k := range []string{"a", "b", "c"} {
+		_, _, _, gen := c.get(k)
+		c.put(k, k, true, gen)
+	}
+	if _, _, ok, _ := c.get("a"); ok {
+		t.Error("least recently used entry not evicted")
+	}
+	if _, _, ok, _ := c.get("c"); !ok {
+		t.Error("newest entry missing")
+	}
+	now = now.Add(time.Minute)
+	if _, _, ok, _ := c.get("c"); ok {
+		t.Error("expired entry still served")
+	}
+	if st := c.stats; st.Evictions != 1 || st.Hits != 1 {
+		t.Errorf("stats = %+v, want 1 eviction and 1 hit", st)
+	}
+}
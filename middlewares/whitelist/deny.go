@@ -0,0 +1,125 @@
+package whitelist
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Hafuunano/Core-SkillAction/cache/database"
+)
+
+const (
+	keyPrefixDenyGroup = "whitelist:denyGroup:"
+	keyPrefixDenyUser  = "whitelist:denyUser:"
+	cmdDenyGroup       = "addBlacklistGroup"
+	cmdUndenyGroup     = "removeBlacklistGroup"
+	cmdDenyUser        = "addBlacklistUser"
+	cmdUndenyUser      = "removeBlacklistUser"
+)
+
+// Deny entries store their expiry as Unix seconds; "0" never expires.
+
+// IsGroupDenied returns true if the group is blacklisted and the ban has not expired.
+func IsGroupDenied(store *database.Store, groupID string) bool {
+	if store == nil || groupID == "" {
+		return false
+	}
+	return denied(store, keyPrefixDenyGroup+groupID)
+}
+
+// IsUserDenied returns true if the user is blacklisted and the ban has not expired.
+func IsUserDenied(store *database.Store, userID string) bool {
+	if store == nil || userID == "" {
+		return false
+	}
+	return denied(store, keyPrefixDenyUser+userID)
+}
+
+// DenyGroup blacklists a group for d; d <= 0 means permanently. operator is recorded in the audit log.
+func DenyGroup(store *database.Store, groupID string, d time.Duration, operator string) error {
+	if store == nil || groupID == "" {
+		return nil
+	}
+	return deny(store, keyPrefixDenyGroup+groupID, d, auditDenyGroup, groupID, operator)
+}
+
+// UndenyGroup removes a group from the blacklist.
+func UndenyGroup(store *database.Store, groupID, operator string) error {
+	if store == nil || groupID == "" {
+		return nil
+	}
+	return undeny(store, keyPrefixDenyGroup+groupID, auditUndenyGroup, groupID, operator)
+}
+
+// DenyUser blacklists a user (in groups and private chat) for d; d <= 0 means permanently.
+func DenyUser(store *database.Store, userID string, d time.Duration, operator string) error {
+	if store == nil || userID == "" {
+		return nil
+	}
+	return deny(store, keyPrefixDenyUser+userID, d, auditDenyUser, userID, operator)
+}
+
+// UndenyUser removes a user from the blacklist.
+func UndenyUser(store *database.Store, userID, operator string) error {
+	if store == nil || userID == "" {
+		return nil
+	}
+	return undeny(store, keyPrefixDenyUser+userID, auditUndenyUser, userID, operator)
+}
+
+func deny(store *database.Store, key string, d time.Duration, action, target, operator string) error {
+	until, detail := int64(0), "permanent"
+	if d > 0 {
+		until, detail = time.Now().Add(d).Unix(), d.String()
+	}
+	if err := set(store, key, strconv.FormatInt(until, 10)); err != nil {
+		return err
+	}
+	audit(store, operator, action, target, detail)
+	return nil
+}
+
+func undeny(store *database.Store, key, action, target, operator string) error {
+	if err := del(store, key); err != nil {
+		return err
+	}
+	audit(store, operator, action, target, "")
+	return nil
+}
+
+// denied reports whether key holds an active ban. Expired bans are deleted on the way.
+func denied(store *database.Store, key string) bool {
+	value, found := lookup(store, key)
+	if !found {
+		return false
+	}
+	until, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || until == 0 || time.Now().Unix() < until {
+		return true
+	}
+	_ = del(store, key)
+	return false
+}
+
+// parseBanDuration accepts time.ParseDuration syntax plus a "d" suffix for days (e.g. "7d").
+func parseBanDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, strconv.ErrSyntax
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, strconv.ErrSyntax
+	}
+	return d, nil
+}
+
+func formatBanDuration(d time.Duration) string {
+	if d <= 0 {
+		return "（永久）"
+	}
+	return "（" + d.String() + " 后解除）"
+}
@@ -0,0 +1,238 @@
+package whitelist
+
+import (
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Hafuunano/Core-SkillAction/cache/database"
+	"github.com/Hafuunano/Protocol-ConvertTool/protocol"
+)
+
+// OneBot v11 request and notice types handled here.
+const (
+	requestTypeGroup      = "group"
+	requestSubTypeInvite  = "invite"
+	noticeGroupIncrease   = "group_increase"
+	defaultAutoLeaveGrace = 10 * time.Minute
+	adminInviteTTL        = time.Hour // how long a super admin's approved invitation exempts the group from auto-leave
+)
+
+// RequestContext is implemented by host contexts for request events (post_type=request). protocol.Context has
+// no way to answer a request, so hosts that dispatch protocol.HookRequest expose it through this interface.
+type RequestContext interface {
+	protocol.Context
+	RequestType() string    // "group" or "friend"
+	RequestSubType() string // "invite" or "add" for group requests
+	ApproveRequest() error
+	RejectRequest(reason string) error
+}
+
+// NoticeContext is implemented by host contexts for notice events (post_type=notice).
+type NoticeContext interface {
+	protocol.Context
+	NoticeType() string // e.g. "group_increase"
+	SelfID() string     // the bot's own user ID
+	LeaveGroup(groupID string) error
+}
+
+// unwrapper is implemented by contexts that wrap another one (e.g. a logging middleware), so the optional
+// interfaces above are still found.
+type unwrapper interface {
+	Unwrap() protocol.Context
+}
+
+var (
+	loggerMu   sync.RWMutex
+	logger     *slog.Logger
+	leaveMu    sync.Mutex
+	autoLeave  bool
+	leaveGrace = defaultAutoLeaveGrace
+	leaveTimer = make(map[string]*time.Timer) // gid -> pending auto-leave
+	leaver     func(groupID string) error     // host-level leave, preferred over the notice's context
+	// adminInvites holds groups a super admin invited the bot to (gid -> when), so joining them does not trigger an auto-leave.
+	adminInvites = make(map[string]time.Time)
+)
+
+// SetLogger sets the slog logger for invitation and auto-leave actions. Nil restores slog.Default().
+func SetLogger(l *slog.Logger) {
+	loggerMu.Lock()
+	defer loggerMu.Unlock()
+	logger = l
+}
+
+func log() *slog.Logger {
+	loggerMu.RLock()
+	defer loggerMu.RUnlock()
+	if logger == nil {
+		return slog.Default()
+	}
+	return logger
+}
+
+// SetAutoLeave makes the bot leave non-whitelisted (or blacklisted) groups it joins, grace after posting a notice there
+// (grace <= 0 uses 10 minutes). Disabled by default. A group whitelisted during the grace period is kept.
+// The leave runs after the notice handler has returned: set SetGroupLeaver, or the host must keep the notice's
+// context usable for LeaveGroup until then.
+func SetAutoLeave(enabled bool, grace time.Duration) {
+	if grace <= 0 {
+		grace = defaultAutoLeaveGrace
+	}
+	leaveMu.Lock()
+	defer leaveMu.Unlock()
+	autoLeave, leaveGrace = enabled, grace
+}
+
+// SetGroupLeaver sets how the bot leaves a group when an auto-leave grace period ends (e.g. backed by the bot's
+// set_group_leave API). Without it the NoticeContext of the join event is kept and its LeaveGroup is used. Nil unsets it.
+func SetGroupLeaver(leave func(groupID string) error) {
+	leaveMu.Lock()
+	defer leaveMu.Unlock()
+	leaver = leave
+}
+
+// groupListed reports whether gid may keep the bot: whitelisted and not blacklisted.
+func groupListed(s *database.Store, gid string) bool {
+	return HasGroup(s, gid) && !IsGroupDenied(s, gid)
+}
+
+func asRequestContext(ctx protocol.Context) (RequestContext, bool) {
+	for ctx != nil {
+		if rc, ok := ctx.(RequestContext); ok {
+			return rc, true
+		}
+		u, ok := ctx.(unwrapper)
+		if !ok {
+			break
+		}
+		ctx = u.Unwrap()
+	}
+	return nil, false
+}
+
+func asNoticeContext(ctx protocol.Context) (NoticeContext, bool) {
+	for ctx != nil {
+		if nc, ok := ctx.(NoticeContext); ok {
+			return nc, true
+		}
+		u, ok := ctx.(unwrapper)
+		if !ok {
+			break
+		}
+		ctx = u.Unwrap()
+	}
+	return nil, false
+}
+
+// reportToSuperAdmins logs an action and sends it to every super admin through the Notifier, if one is set.
+func reportToSuperAdmins(msg string, attrs ...any) {
+	log().Info("whitelist: "+msg, attrs...)
+	n, admins, _ := currentNotifier()
+	if n == nil {
+		return
+	}
+	for _, uid := range admins {
+		_ = n.NotifyUser(uid, msg)
+	}
+}
+
+// handleRequestEvent approves group invitations from super admins and to whitelisted groups, and rejects the rest.
+func handleRequestEvent(ctx protocol.Context) {
+	rc, ok := asRequestContext(ctx)
+	if !ok || rc.RequestType() != requestTypeGroup || rc.RequestSubType() != requestSubTypeInvite {
+		return
+	}
+	storeMu.RLock()
+	s := store
+	storeMu.RUnlock()
+	if s == nil {
+		return
+	}
+	gid, inviter := rc.GroupID(), rc.UserID()
+	if rc.IsSuperAdmin() && !groupListed(s, gid) {
+		if err := rc.ApproveRequest(); err != nil {
+			log().Warn("whitelist: approve invitation failed", "group", gid, "inviter", inviter, "err", err)
+			return
+		}
+		leaveMu.Lock()
+		adminInvites[gid] = time.Now()
+		leaveMu.Unlock()
+		reportToSuperAdmins("已接受超级管理员 "+inviter+" 邀请加入群 "+gid, "action", "approve_invite", "group", gid, "inviter", inviter, "super_admin", true)
+		return
+	}
+	if rc.IsSuperAdmin() || (groupListed(s, gid) && !IsUserDenied(s, inviter)) {
+		if err := rc.ApproveRequest(); err != nil {
+			log().Warn("whitelist: approve invitation failed", "group", gid, "inviter", inviter, "err", err)
+			return
+		}
+		reportToSuperAdmins("已接受 "+inviter+" 邀请加入白名单群 "+gid, "action", "approve_invite", "group", gid, "inviter", inviter)
+		return
+	}
+	if err := rc.RejectRequest("该群不在白名单中"); err != nil {
+		log().Warn("whitelist: reject invitation failed", "group", gid, "inviter", inviter, "err", err)
+		return
+	}
+	reportToSuperAdmins("已拒绝 "+inviter+" 邀请加入非白名单群 "+gid, "action", "reject_invite", "group", gid, "inviter", inviter)
+}
+
+// handleNoticeEvent schedules an auto-leave when the bot itself joins a non-whitelisted or blacklisted group and auto-leave is on.
+// Groups a super admin invited the bot to are kept.
+func handleNoticeEvent(ctx protocol.Context) {
+	nc, ok := asNoticeContext(ctx)
+	if !ok || nc.NoticeType() != noticeGroupIncrease || nc.UserID() != nc.SelfID() {
+		return
+	}
+	gid := nc.GroupID()
+	leaveMu.Lock()
+	enabled, grace := autoLeave, leaveGrace
+	invitedAt, invited := adminInvites[gid]
+	delete(adminInvites, gid)
+	leaveMu.Unlock()
+	if invited && time.Since(invitedAt) < adminInviteTTL {
+		return
+	}
+	storeMu.RLock()
+	s := store
+	storeMu.RUnlock()
+	if !enabled || s == nil || gid == "" || gid == "0" || groupListed(s, gid) {
+		return
+	}
+	minutes := strconv.Itoa(int(grace.Round(time.Minute) / time.Minute))
+	if IsGroupDenied(s, gid) {
+		_ = nc.SendPlainMessage("本群已被禁用，" + minutes + " 分钟后将自动退群")
+	} else {
+		_ = nc.SendPlainMessage("本群不在白名单中，" + minutes + " 分钟后将自动退群。群管理员可发送 " + nc.CommandPrefix() + cmdRequest + " <理由> 申请白名单")
+	}
+	reportToSuperAdmins("已加入非白名单群 "+gid+"，将在 "+minutes+" 分钟后自动退出", "action", "schedule_leave", "group", gid, "grace", grace)
+	leaveMu.Lock()
+	defer leaveMu.Unlock()
+	if t := leaveTimer[gid]; t != nil {
+		t.Stop()
+	}
+	// Keep only the leave function, not the whole event context, unless the host gave no leaver.
+	leave := leaver
+	if leave == nil {
+		leave = nc.LeaveGroup
+	}
+	leaveTimer[gid] = time.AfterFunc(grace, func() { leaveIfStillUnlisted(leave, gid) })
+}
+
+// leaveIfStillUnlisted runs when the grace period ends; the group may have been whitelisted in the meantime.
+func leaveIfStillUnlisted(leave func(groupID string) error, gid string) {
+	leaveMu.Lock()
+	delete(leaveTimer, gid)
+	leaveMu.Unlock()
+	storeMu.RLock()
+	s := store
+	storeMu.RUnlock()
+	if s == nil || groupListed(s, gid) {
+		reportToSuperAdmins("群 "+gid+" 已在白名单中，取消自动退群", "action", "cancel_leave", "group", gid)
+		return
+	}
+	if err := leave(gid); err != nil {
+		log().Warn("whitelist: leave group failed", "group", gid, "err", err)
+		return
+	}
+	reportToSuperAdmins("已自动退出非白名单群 "+gid, "action", "leave", "group", gid)
+}
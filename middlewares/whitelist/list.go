@@ -0,0 +1,213 @@
+package whitelist
+
+import (
+	"encoding/json"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Hafuunano/Core-SkillAction/cache/database"
+	"github.com/Hafuunano/Protocol-ConvertTool/protocol"
+)
+
+const (
+	// Index keys hold sorted JSON arrays of whitelisted IDs, so listing never walks the shared store.
+	keyIndexGroups = "whitelist:index:groups"
+	keyIndexUsers  = "whitelist:index:users"
+	cmdListGroups  = "listWhitelistGroups"
+	cmdListUsers   = "listWhitelistUsers"
+	cmdCheck       = "checkWhitelist"
+	listPageSize   = 20
+)
+
+// indexMu serializes index read-modify-write and the one-time migration.
+var indexMu sync.Mutex
+
+// ListGroups returns the whitelisted group IDs in ascending order.
+func ListGroups(store *database.Store) []string {
+	return listIndex(store, keyIndexGroups, keyPrefixGroup)
+}
+
+// ListUsers returns the whitelisted user IDs in ascending order.
+func ListUsers(store *database.Store) []string {
+	return listIndex(store, keyIndexUsers, keyPrefixUser)
+}
+
+// AddGroups adds several groups to the whitelist with one index update. Empty IDs are ignored.
+// operator (a user ID, or "" for host tooling) is recorded in the audit log, as for every change below.
+func AddGroups(store *database.Store, groupIDs []string, operator string) error {
+	return addIDs(store, keyIndexGroups, keyPrefixGroup, groupIDs, auditAddGroup, operator)
+}
+
+// RemoveGroups removes several groups from the whitelist.
+func RemoveGroups(store *database.Store, groupIDs []string, operator string) error {
+	return removeIDs(store, keyIndexGroups, keyPrefixGroup, groupIDs, auditRemoveGroup, operator)
+}
+
+// AddUsers adds several users to the private-chat whitelist.
+func AddUsers(store *database.Store, userIDs []string, operator string) error {
+	return addIDs(store, keyIndexUsers, keyPrefixUser, userIDs, auditAddUser, operator)
+}
+
+// RemoveUsers removes several users from the private-chat whitelist.
+func RemoveUsers(store *database.Store, userIDs []string, operator string) error {
+	return removeIDs(store, keyIndexUsers, keyPrefixUser, userIDs, auditRemoveUser, operator)
+}
+
+func listIndex(store *database.Store, indexKey, prefix string) []string {
+	if store == nil {
+		return nil
+	}
+	indexMu.Lock()
+	defer indexMu.Unlock()
+	return loadIndex(store, indexKey, prefix)
+}
+
+func addIDs(store *database.Store, indexKey, prefix string, ids []string, action, operator string) error {
+	if store == nil {
+		return nil
+	}
+	indexMu.Lock()
+	defer indexMu.Unlock()
+	index := loadIndex(store, indexKey, prefix)
+	for _, id := range ids {
+		if id == "" {
+			continue
+		}
+		if err := set(store, prefix+id, "1"); err != nil {
+			return err
+		}
+		audit(store, operator, action, id, "")
+		if i, found := slices.BinarySearch(index, id); !found {
+			index = slices.Insert(index, i, id)
+		}
+	}
+	return saveIndex(store, indexKey, index)
+}
+
+func removeIDs(store *database.Store, indexKey, prefix string, ids []string, action, operator string) error {
+	if store == nil {
+		return nil
+	}
+	indexMu.Lock()
+	defer indexMu.Unlock()
+	index := loadIndex(store, indexKey, prefix)
+	for _, id := range ids {
+		if id == "" {
+			continue
+		}
+		if err := del(store, prefix+id); err != nil {
+			return err
+		}
+		audit(store, operator, action, id, "")
+		if i, found := slices.BinarySearch(index, id); found {
+			index = slices.Delete(index, i, i+1)
+		}
+	}
+	return saveIndex(store, indexKey, index)
+}
+
+// loadIndex reads indexKey; when it does not exist yet (stores written before the index) it is built
+// once from the prefix keys with a full scan. Caller holds indexMu.
+func loadIndex(store *database.Store, indexKey, prefix string) []string {
+	raw, found, _ := store.Get(indexKey)
+	var ids []string
+	if found && json.Unmarshal([]byte(raw), &ids) == nil {
+		return ids
+	}
+	ids = ids[:0]
+	for _, e := range store.List() {
+		if id, ok := strings.CutPrefix(e.Key, prefix); ok && id != "" {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	ids = slices.Compact(ids)
+	_ = saveIndex(store, indexKey, ids)
+	return ids
+}
+
+func saveIndex(store *database.Store, indexKey string, ids []string) error {
+	if ids == nil {
+		ids = []string{}
+	}
+	raw, err := json.Marshal(ids)
+	if err != nil {
+		return err
+	}
+	return store.Set(indexKey, string(raw))
+}
+
+// handleListCommands handles listWhitelistGroups [page], listWhitelistUsers [page] and checkWhitelist <id> (super admin only).
+func handleListCommands(ctx protocol.Context) {
+	parts := strings.Fields(strings.TrimSpace(ctx.PlainText()))
+	if len(parts) == 0 {
+		return
+	}
+	prefix := ctx.CommandPrefix()
+	cmd, ok := strings.CutPrefix(parts[0], prefix)
+	if !ok || (cmd != cmdListGroups && cmd != cmdListUsers && cmd != cmdCheck) {
+		return
+	}
+	storeMu.RLock()
+	s := store
+	storeMu.RUnlock()
+	if s == nil {
+		_ = ctx.SendPlainMessage("whitelist store 未初始化")
+		return
+	}
+	if cmd == cmdCheck {
+		if len(parts) < 2 {
+			_ = ctx.SendPlainMessage("用法: " + prefix + cmdCheck + " <群号|用户ID>")
+			return
+		}
+		_ = ctx.SendPlainMessage(formatCheck(s, parts[1]))
+		return
+	}
+	page := 1
+	if len(parts) > 1 {
+		n, err := strconv.Atoi(parts[1])
+		if err != nil || n < 1 {
+			_ = ctx.SendPlainMessage("用法: " + prefix + cmd + " [页码]")
+			return
+		}
+		page = n
+	}
+	ids, label := ListGroups(s), "白名单群"
+	if cmd == cmdListUsers {
+		ids, label = ListUsers(s), "白名单用户"
+	}
+	_ = ctx.SendPlainMessage(formatPage(label, ids, page))
+}
+
+// formatPage renders one page of ids, e.g. "白名单群（第 1/3 页，共 45 个）".
+func formatPage(label string, ids []string, page int) string {
+	if len(ids) == 0 {
+		return label + "为空"
+	}
+	pages := (len(ids) + listPageSize - 1) / listPageSize
+	page = min(page, pages)
+	start := (page - 1) * listPageSize
+	end := min(start+listPageSize, len(ids))
+	head := label + "（第 " + strconv.Itoa(page) + "/" + strconv.Itoa(pages) + " 页，共 " + strconv.Itoa(len(ids)) + " 个）："
+	return head + "\n" + strings.Join(ids[start:end], "\n")
+}
+
+// formatCheck reports where id appears, as a group and as a user (QQ-style IDs can be either).
+func formatCheck(s *database.Store, id string) string {
+	yes := func(b bool) string {
+		if b {
+			return "是"
+		}
+		return "否"
+	}
+	lines := []string{
+		id + "：",
+		"群白名单：" + yes(HasGroup(s, id)),
+		"用户白名单：" + yes(HasUser(s, id)),
+		"群黑名单：" + yes(IsGroupDenied(s, id)),
+		"用户黑名单：" + yes(IsUserDenied(s, id)),
+	}
+	return strings.Join(lines, "\n")
+}
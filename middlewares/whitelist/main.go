@@ -1,33 +1,29 @@
-// Package whitelist provides a group whitelist middleware. Use per-plugin (Middleware + SetStore) or inject globally: zerobot.InstallWithMiddlewares([]protocol.Middleware{whitelist.New(services.Cache)}). Group: whitelisted groups and super admin pass. Private chat: only super admin or user-ID in user whitelist pass.
+// Package whitelist provides a group whitelist middleware. Use per-plugin (Middleware + SetStore) or inject globally: zerobot.InstallWithMiddlewares([]protocol.Middleware{whitelist.New(services.Cache)}). Group: whitelisted groups and super admin pass. Private chat: only super admin or user-ID in user whitelist pass. Deny lists (blacklist, optionally expiring) are checked first: blacklisted users and groups are silently ignored. Per-plugin ACLs: PluginMiddleware(Meta). Group admins can requestWhitelist; super admins approve or reject (host supplies SetNotifier for notifications). On request/notice hooks, group invitations are answered by whitelist and non-whitelisted groups optionally left (SetAutoLeave). Every change is recorded with its operator (whitelistAudit / AuditLog).
 package whitelist
 
 import (
 	"strings"
 	"sync"
+	"time"
 
-	skillcore "github.com/Hafuunano/Core-SkillAction/core"
 	"github.com/Hafuunano/Core-SkillAction/cache/database"
+	skillcore "github.com/Hafuunano/Core-SkillAction/core"
 	"github.com/Hafuunano/Core-SkillAction/types"
 	"github.com/Hafuunano/Protocol-ConvertTool/protocol"
 )
 
 const (
-	keyPrefixGroup   = "whitelist:group:"
-	keyPrefixUser    = "whitelist:user:"
-	cmdAddGroup      = "addWhitelistGroup"
-	cmdRemoveGroup   = "removeWhitelistGroup"
-	cmdAddUser       = "addWhitelistUser"
-	cmdRemoveUser    = "removeWhitelistUser"
-	negativeCacheHit = 3 // after N consecutive "not found", cache in memory and skip store lookup
+	keyPrefixGroup = "whitelist:group:"
+	keyPrefixUser  = "whitelist:user:"
+	cmdAddGroup    = "addWhitelistGroup"
+	cmdRemoveGroup = "removeWhitelistGroup"
+	cmdAddUser     = "addWhitelistUser"
+	cmdRemoveUser  = "removeWhitelistUser"
 )
 
 var (
-	storeMu         sync.RWMutex
-	store           *database.Store
-	negativeCacheMu sync.RWMutex
-	negativeCache   = make(map[string]struct{}) // keys that are known "not in whitelist", skip store
-	notFoundCountMu sync.Mutex
-	notFoundCount   = make(map[string]int) // key -> consecutive "not found" count
+	storeMu sync.RWMutex
+	store   *database.Store
 )
 
 // Meta is this middleware's metadata for config (MiddlewareName etc.).
@@ -46,9 +42,17 @@ func SetStore(s *database.Store) {
 func init() {
 	SetStore(skillcore.DefaultCache()) // so command handler and Middleware/New() share the same store
 	protocol.Engine.WithMeta(Meta).OnMessage().IsOnlySuperAdmin().Func(handleWhitelistCommands)
+	protocol.Engine.WithMeta(Meta).OnMessage().IsOnlySuperAdmin().Func(handleListCommands)
+	protocol.Engine.WithMeta(Meta).OnMessage().IsOnlySuperAdmin().Func(handleAuditCommand)
+	protocol.Engine.WithMeta(Meta).OnMessage().IsOnlySuperAdmin().Func(handleACLCommands)
+	protocol.Engine.WithMeta(Meta).OnMessage().IsOnlySuperAdmin().Func(handleRequestAdminCommands)
+	protocol.Engine.WithMeta(Meta).OnMessage().Func(handleRequestCommand)
+	// Request and notice chains run only when the host dispatches them with RequestContext/NoticeContext (see events.go).
+	protocol.RegisterOn(protocol.HookRequest, handleRequestEvent)
+	protocol.RegisterOn(protocol.HookNotice, handleNoticeEvent)
 }
 
-// handleWhitelistCommands handles addWhitelistGroup, removeWhitelistGroup, addWhitelistUser, removeWhitelistUser (super admin only).
+// handleWhitelistCommands handles addWhitelistGroup, removeWhitelistGroup, addWhitelistUser, removeWhitelistUser and the blacklist commands (super admin only).
 func handleWhitelistCommands(ctx protocol.Context) {
 	text := strings.TrimSpace(ctx.PlainText())
 	prefix := ctx.CommandPrefix()
@@ -67,98 +71,87 @@ func handleWhitelistCommands(ctx protocol.Context) {
 	arg := parts[1]
 	switch cmd {
 	case prefix + cmdAddGroup:
-		if err := AddGroup(s, arg); err != nil {
+		// {prefix}addWhitelistGroup gid [gid ...]
+		if err := AddGroups(s, parts[1:], ctx.UserID()); err != nil {
 			_ = ctx.SendPlainMessage("添加群白名单失败")
 			return
 		}
-		_ = ctx.SendPlainMessage("已添加群 " + arg + " 至白名单")
+		_ = ctx.SendPlainMessage("已添加群 " + strings.Join(parts[1:], "、") + " 至白名单")
 	case prefix + cmdRemoveGroup:
-		_ = RemoveGroup(s, arg)
-		_ = ctx.SendPlainMessage("已从白名单移除群 " + arg)
+		_ = RemoveGroups(s, parts[1:], ctx.UserID())
+		_ = ctx.SendPlainMessage("已从白名单移除群 " + strings.Join(parts[1:], "、"))
 	case prefix + cmdAddUser:
-		if err := AddUser(s, arg); err != nil {
+		if err := AddUsers(s, parts[1:], ctx.UserID()); err != nil {
 			_ = ctx.SendPlainMessage("添加用户白名单失败")
 			return
 		}
-		_ = ctx.SendPlainMessage("已添加用户 " + arg + " 至白名单")
+		_ = ctx.SendPlainMessage("已添加用户 " + strings.Join(parts[1:], "、") + " 至白名单")
 	case prefix + cmdRemoveUser:
-		_ = RemoveUser(s, arg)
-		_ = ctx.SendPlainMessage("已从白名单移除用户 " + arg)
-	default:
-		// not a whitelist command
-	}
-}
-
-// Middleware wraps a single plugin handler: the plugin runs only in whitelisted groups or for whitelisted private users. Super admin always passes. Use when registering the plugin, e.g. protocol.Engine.WithMeta(Meta).OnMessage().Func(whitelist.Middleware(Plugin)).
-func Middleware(next protocol.Handler) protocol.Handler {
-	return func(ctx protocol.Context) {
-		gid := ctx.GroupID()
-		isPrivate := gid == "" || gid == "0"
-		if isPrivate {
-			if ctx.IsSuperAdmin() {
-				next(ctx)
-				return
-			}
-			storeMu.RLock()
-			s := store
-			storeMu.RUnlock()
-			if s == nil || !HasUser(s, ctx.UserID()) {
+		_ = RemoveUsers(s, parts[1:], ctx.UserID())
+		_ = ctx.SendPlainMessage("已从白名单移除用户 " + strings.Join(parts[1:], "、"))
+	case prefix + cmdDenyGroup, prefix + cmdDenyUser:
+		// {prefix}addBlacklistUser uid [duration], e.g. 24h or 7d; no duration means permanent
+		var d time.Duration
+		if len(parts) > 2 {
+			var err error
+			if d, err = parseBanDuration(parts[2]); err != nil {
+				_ = ctx.SendPlainMessage("无效的时长 " + parts[2] + "，例如 30m、24h、7d")
 				return
 			}
-			next(ctx)
-			return
 		}
-		if ctx.IsSuperAdmin() {
-			next(ctx)
-			return
+		target, add := "用户 ", DenyUser
+		if cmd == prefix+cmdDenyGroup {
+			target, add = "群 ", DenyGroup
 		}
-		storeMu.RLock()
-		s := store
-		storeMu.RUnlock()
-		if s != nil && !HasGroup(s, gid) {
+		if err := add(s, arg, d, ctx.UserID()); err != nil {
+			_ = ctx.SendPlainMessage("添加黑名单失败")
 			return
 		}
-		next(ctx)
+		_ = ctx.SendPlainMessage("已将" + target + arg + " 加入黑名单" + formatBanDuration(d))
+	case prefix + cmdUndenyGroup:
+		_ = UndenyGroup(s, arg, ctx.UserID())
+		_ = ctx.SendPlainMessage("已从黑名单移除群 " + arg)
+	case prefix + cmdUndenyUser:
+		_ = UndenyUser(s, arg, ctx.UserID())
+		_ = ctx.SendPlainMessage("已从黑名单移除用户 " + arg)
+	default:
+		// not a whitelist command
 	}
 }
 
-// New returns a protocol.Middleware that uses the given store. Super admin always passes; private chat only if user ID in user whitelist. Use for global injection, e.g. zerobot.InstallWithMiddlewares([]protocol.Middleware{whitelist.New(services.Cache)}), or when you have a store at hand.
+// defaultPolicy backs Middleware: classic rules with the store set by SetStore.
+var defaultPolicy = NewPolicy()
+
+// Middleware wraps a single plugin handler: the plugin runs only in whitelisted groups or for whitelisted private users. Super admin always passes; blacklisted users and groups never do. Use when registering the plugin, e.g. protocol.Engine.WithMeta(Meta).OnMessage().Func(whitelist.Middleware(Plugin)). For other rules build a Policy with NewPolicy.
+func Middleware(next protocol.Handler) protocol.Handler {
+	return defaultPolicy.Wrap(next)
+}
+
+// New returns a protocol.Middleware that uses the given store. Super admin always passes; blacklisted users and groups are ignored; private chat only if user ID in user whitelist. Use for global injection, e.g. zerobot.InstallWithMiddlewares([]protocol.Middleware{whitelist.New(services.Cache)}), or when you have a store at hand. Same as NewPolicy(WithStore(s)).Middleware().
 func New(s *database.Store) protocol.Middleware {
-	return func(next protocol.Handler) protocol.Handler {
-		return func(ctx protocol.Context) {
-			gid := ctx.GroupID()
-			isPrivate := gid == "" || gid == "0"
-			if isPrivate {
-				if ctx.IsSuperAdmin() {
-					next(ctx)
-					return
-				}
-				if s == nil || !HasUser(s, ctx.UserID()) {
-					return
-				}
-				next(ctx)
-				return
-			}
-			if ctx.IsSuperAdmin() {
-				next(ctx)
-				return
-			}
-			if s != nil && !HasGroup(s, gid) {
-				return
-			}
-			next(ctx)
-		}
+	return NewPolicy(WithStore(s)).Middleware()
+}
+
+// lookup reads key through the TTL-bounded cache (see cache.go), so hot checks of listed and unlisted IDs skip the store.
+func lookup(store *database.Store, key string) (string, bool) {
+	value, found, ok, gen := cache.get(key)
+	if ok {
+		return value, found
 	}
+	value, found, _ = store.Get(key)
+	cache.put(key, value, found, gen)
+	return value, found
+}
+
+// set and del write key and drop its cached read.
+func set(store *database.Store, key, value string) error {
+	defer cache.invalidate(key)
+	return store.Set(key, value)
 }
 
-// removeFromNegativeCache removes key from negative cache and resets its not-found count (call when adding to whitelist).
-func removeFromNegativeCache(key string) {
-	negativeCacheMu.Lock()
-	delete(negativeCache, key)
-	negativeCacheMu.Unlock()
-	notFoundCountMu.Lock()
-	delete(notFoundCount, key)
-	notFoundCountMu.Unlock()
+func del(store *database.Store, key string) error {
+	defer cache.invalidate(key)
+	return store.Delete(key)
 }
 
 // HasGroup returns true if the group is in the whitelist.
@@ -166,47 +159,24 @@ func HasGroup(store *database.Store, groupID string) bool {
 	if store == nil || groupID == "" {
 		return false
 	}
-	key := keyPrefixGroup + groupID
-	negativeCacheMu.RLock()
-	_, inNegative := negativeCache[key]
-	negativeCacheMu.RUnlock()
-	if inNegative {
-		return false
-	}
-	_, found, _ := store.Get(key)
-	if found {
-		notFoundCountMu.Lock()
-		delete(notFoundCount, key)
-		notFoundCountMu.Unlock()
-		return true
-	}
-	notFoundCountMu.Lock()
-	notFoundCount[key]++
-	if notFoundCount[key] >= negativeCacheHit {
-		negativeCacheMu.Lock()
-		negativeCache[key] = struct{}{}
-		negativeCacheMu.Unlock()
-		delete(notFoundCount, key)
-	}
-	notFoundCountMu.Unlock()
-	return false
+	_, found := lookup(store, keyPrefixGroup+groupID)
+	return found
 }
 
 // AddGroup adds a group to the whitelist.
-func AddGroup(store *database.Store, groupID string) error {
+func AddGroup(store *database.Store, groupID, operator string) error {
 	if store == nil || groupID == "" {
 		return nil
 	}
-	removeFromNegativeCache(keyPrefixGroup + groupID)
-	return store.Set(keyPrefixGroup+groupID, "1")
+	return AddGroups(store, []string{groupID}, operator)
 }
 
 // RemoveGroup removes a group from the whitelist.
-func RemoveGroup(store *database.Store, groupID string) error {
+func RemoveGroup(store *database.Store, groupID, operator string) error {
 	if store == nil || groupID == "" {
 		return nil
 	}
-	return store.Delete(keyPrefixGroup + groupID)
+	return RemoveGroups(store, []string{groupID}, operator)
 }
 
 // HasUser returns true if the user is in the private-chat user whitelist.
@@ -214,45 +184,22 @@ func HasUser(store *database.Store, userID string) bool {
 	if store == nil || userID == "" {
 		return false
 	}
-	key := keyPrefixUser + userID
-	negativeCacheMu.RLock()
-	_, inNegative := negativeCache[key]
-	negativeCacheMu.RUnlock()
-	if inNegative {
-		return false
-	}
-	_, found, _ := store.Get(key)
-	if found {
-		notFoundCountMu.Lock()
-		delete(notFoundCount, key)
-		notFoundCountMu.Unlock()
-		return true
-	}
-	notFoundCountMu.Lock()
-	notFoundCount[key]++
-	if notFoundCount[key] >= negativeCacheHit {
-		negativeCacheMu.Lock()
-		negativeCache[key] = struct{}{}
-		negativeCacheMu.Unlock()
-		delete(notFoundCount, key)
-	}
-	notFoundCountMu.Unlock()
-	return false
+	_, found := lookup(store, keyPrefixUser+userID)
+	return found
 }
 
 // AddUser adds a user to the private-chat whitelist (by user ID).
-func AddUser(store *database.Store, userID string) error {
+func AddUser(store *database.Store, userID, operator string) error {
 	if store == nil || userID == "" {
 		return nil
 	}
-	removeFromNegativeCache(keyPrefixUser + userID)
-	return store.Set(keyPrefixUser+userID, "1")
+	return AddUsers(store, []string{userID}, operator)
 }
 
 // RemoveUser removes a user from the private-chat whitelist.
-func RemoveUser(store *database.Store, userID string) error {
+func RemoveUser(store *database.Store, userID, operator string) error {
 	if store == nil || userID == "" {
 		return nil
 	}
-	return store.Delete(keyPrefixUser + userID)
+	return RemoveUsers(store, []string{userID}, operator)
 }
@@ -0,0 +1,192 @@
+package whitelist
+
+import (
+	"strings"
+
+	"github.com/Hafuunano/Core-SkillAction/cache/database"
+	"github.com/Hafuunano/Protocol-ConvertTool/protocol"
+)
+
+// Policy decides whether a message reaches the wrapped handler. Build one with NewPolicy; the zero options
+// give the classic whitelist: super admin bypass, deny lists first, group whitelist in groups, user whitelist in private chat.
+type Policy struct {
+	store            *database.Store
+	storeSet         bool // false: use the store set by SetStore, read at decision time
+	privateOpen      bool
+	requireGroup     bool
+	superAdminBypass bool
+	predicates       []namedPredicate
+}
+
+// Option configures a Policy.
+type Option func(*Policy)
+
+// Predicate is a custom check; returning false drops the message.
+type Predicate func(ctx protocol.Context) bool
+
+type namedPredicate struct {
+	name string
+	fn   Predicate
+}
+
+// WithStore makes the policy use s instead of the store set by SetStore. A nil s lets groups through and blocks private chat.
+func WithStore(s *database.Store) Option {
+	return func(p *Policy) { p.store, p.storeSet = s, true }
+}
+
+// AllowPrivateForAll opens private chat to every user (deny lists still apply).
+func AllowPrivateForAll() Option {
+	return func(p *Policy) { p.privateOpen = true }
+}
+
+// RequireGroupWhitelist sets whether groups must be whitelisted (default true). With false, every group not on a deny list passes.
+func RequireGroupWhitelist(required bool) Option {
+	return func(p *Policy) { p.requireGroup = required }
+}
+
+// SuperAdminBypass sets whether super admins skip every check (default true).
+func SuperAdminBypass(bypass bool) Option {
+	return func(p *Policy) { p.superAdminBypass = bypass }
+}
+
+// WithPredicate adds a custom check, run after the built-in ones; name appears in the decision trace.
+func WithPredicate(name string, fn Predicate) Option {
+	return func(p *Policy) { p.predicates = append(p.predicates, namedPredicate{name: name, fn: fn}) }
+}
+
+// NewPolicy builds a Policy from opts.
+func NewPolicy(opts ...Option) *Policy {
+	p := &Policy{requireGroup: true, superAdminBypass: true}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Decision is the outcome of Policy.Decide with every step taken, for debugging why a message was dropped.
+type Decision struct {
+	Allowed bool
+	Trace   []string // e.g. ["group=123 user=456", "super_admin=false", "user_denied=false", "group_whitelisted=false", "drop: group not whitelisted"]
+
+	groupNotListed bool // dropped only because the group is not whitelisted; Middleware still serves requestWhitelist
+}
+
+func (d Decision) String() string {
+	return strings.Join(d.Trace, "; ")
+}
+
+// Decide evaluates ctx and records each step.
+func (p *Policy) Decide(ctx protocol.Context) Decision {
+	var d Decision
+	step := func(s string) { d.Trace = append(d.Trace, s) }
+	done := func(allowed bool, why string) Decision {
+		d.Allowed = allowed
+		if allowed {
+			step("pass: " + why)
+		} else {
+			step("drop: " + why)
+		}
+		return d
+	}
+	gid, uid := ctx.GroupID(), ctx.UserID()
+	isPrivate := gid == "" || gid == "0"
+	step("group=" + gid + " user=" + uid)
+	if p.superAdminBypass {
+		admin := ctx.IsSuperAdmin()
+		step("super_admin=" + yesNo(admin))
+		if admin {
+			return done(true, "super admin bypass")
+		}
+	}
+	s := p.store
+	if !p.storeSet {
+		storeMu.RLock()
+		s = store
+		storeMu.RUnlock()
+	}
+	if s == nil {
+		step("store=nil")
+	} else {
+		denied := IsUserDenied(s, uid)
+		step("user_denied=" + yesNo(denied))
+		if denied {
+			return done(false, "user blacklisted")
+		}
+	}
+	if isPrivate {
+		switch {
+		case p.privateOpen:
+			step("private_open=true")
+		case s == nil:
+			return done(false, "private chat without store")
+		default:
+			listed := HasUser(s, uid)
+			step("user_whitelisted=" + yesNo(listed))
+			if !listed {
+				return done(false, "user not whitelisted")
+			}
+		}
+	} else if s != nil {
+		denied := IsGroupDenied(s, gid)
+		step("group_denied=" + yesNo(denied))
+		if denied {
+			return done(false, "group blacklisted")
+		}
+		if p.requireGroup {
+			listed := HasGroup(s, gid)
+			step("group_whitelisted=" + yesNo(listed))
+			if !listed {
+				d.groupNotListed = true
+				return done(false, "group not whitelisted")
+			}
+		}
+	}
+	for _, pr := range p.predicates {
+		ok := pr.fn(ctx)
+		step(pr.name + "=" + yesNo(ok))
+		if !ok {
+			return done(false, "predicate "+pr.name)
+		}
+	}
+	return done(true, "all checks passed")
+}
+
+// Allowed reports whether ctx passes the policy.
+func (p *Policy) Allowed(ctx protocol.Context) bool {
+	return p.Decide(ctx).Allowed
+}
+
+// Wrap returns next guarded by the policy, for a single plugin handler. Dropped messages are logged at debug level with the decision trace.
+func (p *Policy) Wrap(next protocol.Handler) protocol.Handler {
+	return p.wrap(next, false)
+}
+
+// Middleware returns the policy as a protocol.Middleware for the whole chain (see New). In a group that is not
+// whitelisted it answers requestWhitelist itself, so the group can still ask to join while no plugin sees the message.
+func (p *Policy) Middleware() protocol.Middleware {
+	return func(next protocol.Handler) protocol.Handler {
+		return p.wrap(next, true)
+	}
+}
+
+func (p *Policy) wrap(next protocol.Handler, serveRequests bool) protocol.Handler {
+	return func(ctx protocol.Context) {
+		d := p.Decide(ctx)
+		if !d.Allowed {
+			log().Debug("whitelist: message dropped", "trace", d.String())
+			// The host runs the default chain for every message and the reply chain again for @-bot ones; answer once.
+			if serveRequests && d.groupNotListed && !ctx.IsOnlyToMe() {
+				handleRequestCommand(ctx)
+			}
+			return
+		}
+		next(ctx)
+	}
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "true"
+	}
+	return "false"
+}
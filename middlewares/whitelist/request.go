@@ -0,0 +1,336 @@
+package whitelist
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Hafuunano/Core-SkillAction/cache/database"
+	"github.com/Hafuunano/Protocol-ConvertTool/protocol"
+)
+
+const (
+	keyPrefixRequest  = "whitelist:request:"  // + group ID, one pending request per group
+	keyRequestIndex   = "whitelist:requests"  // JSON array of group IDs with a pending request
+	keyPrefixRejected = "whitelist:rejected:" // + group ID, RFC3339 time of the last rejected request
+	cmdRequest        = "requestWhitelist"
+	cmdApprove        = "approveWhitelist"
+	cmdReject         = "rejectWhitelist"
+	cmdListRequests   = "listWhitelistRequests"
+	defaultRequestTTL = 72 * time.Hour
+	// defaultRejectCooldown keeps a rejected group from re-submitting (and re-notifying every super admin) at once.
+	defaultRejectCooldown = 24 * time.Hour
+	maxReasonLen          = 200 // runes
+)
+
+// Notifier delivers messages outside the current conversation. protocol.Context can only reply where the
+// message came from, so the host provides this (e.g. backed by the bot's send-private/send-group API).
+type Notifier interface {
+	NotifyUser(userID, text string) error
+	NotifyGroup(groupID, text string) error
+}
+
+// WhitelistRequest is a group admin's pending request, stored as JSON at whitelist:request:{gid}.
+type WhitelistRequest struct {
+	GroupID   string `json:"groupId"`
+	UserID    string `json:"userId"`
+	Nickname  string `json:"nickname"`
+	Reason    string `json:"reason"`
+	CreatedAt string `json:"createdAt"` // RFC3339
+}
+
+var (
+	notifyMu    sync.RWMutex
+	notifier    Notifier
+	superAdmins []string
+	requestTTL  = defaultRequestTTL
+	rejectCool  = defaultRejectCooldown
+	requestMu   sync.Mutex // serializes request writes, rejection markers and the index
+)
+
+// SetNotifier sets how super admins (by user ID) are told about new requests and how groups learn the outcome.
+// Without a notifier requests are still queued; super admins see them with listWhitelistRequests.
+func SetNotifier(n Notifier, superAdminIDs []string) {
+	notifyMu.Lock()
+	defer notifyMu.Unlock()
+	notifier = n
+	superAdmins = slices.Clone(superAdminIDs)
+}
+
+// SetRequestTTL sets how long a request stays pending before it expires; d <= 0 restores the default (72h).
+func SetRequestTTL(d time.Duration) {
+	if d <= 0 {
+		d = defaultRequestTTL
+	}
+	notifyMu.Lock()
+	defer notifyMu.Unlock()
+	requestTTL = d
+}
+
+// SetRejectCooldown sets how long a group must wait after a rejection before requesting again; d <= 0 restores the default (24h).
+func SetRejectCooldown(d time.Duration) {
+	if d <= 0 {
+		d = defaultRejectCooldown
+	}
+	notifyMu.Lock()
+	defer notifyMu.Unlock()
+	rejectCool = d
+}
+
+func currentRejectCooldown() time.Duration {
+	notifyMu.RLock()
+	defer notifyMu.RUnlock()
+	return rejectCool
+}
+
+func currentNotifier() (Notifier, []string, time.Duration) {
+	notifyMu.RLock()
+	defer notifyMu.RUnlock()
+	return notifier, superAdmins, requestTTL
+}
+
+// PendingRequests returns the unexpired requests, oldest first; expired ones are deleted on the way.
+func PendingRequests(store *database.Store) []WhitelistRequest {
+	if store == nil {
+		return nil
+	}
+	requestMu.Lock()
+	defer requestMu.Unlock()
+	_, _, ttl := currentNotifier()
+	var out []WhitelistRequest
+	for _, gid := range loadRequestIndex(store) {
+		if r, ok := loadRequest(store, gid, ttl); ok {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// loadRequest returns gid's pending request; an expired or unreadable one is removed. Caller holds requestMu.
+func loadRequest(store *database.Store, gid string, ttl time.Duration) (WhitelistRequest, bool) {
+	var r WhitelistRequest
+	raw, found, _ := store.Get(keyPrefixRequest + gid)
+	if found && json.Unmarshal([]byte(raw), &r) == nil {
+		if t, err := time.Parse(time.RFC3339, r.CreatedAt); err == nil && time.Since(t) < ttl {
+			return r, true
+		}
+	}
+	removeRequest(store, gid)
+	return WhitelistRequest{}, false
+}
+
+func saveRequest(store *database.Store, r WhitelistRequest) error {
+	raw, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if err := store.Set(keyPrefixRequest+r.GroupID, string(raw)); err != nil {
+		return err
+	}
+	ids := loadRequestIndex(store)
+	if !slices.Contains(ids, r.GroupID) {
+		return saveRequestIndex(store, append(ids, r.GroupID))
+	}
+	return nil
+}
+
+func removeRequest(store *database.Store, gid string) {
+	_ = store.Delete(keyPrefixRequest + gid)
+	ids := loadRequestIndex(store)
+	if i := slices.Index(ids, gid); i >= 0 {
+		_ = saveRequestIndex(store, slices.Delete(ids, i, i+1))
+	}
+}
+
+// rejectedRecently returns how long gid must still wait after its last rejection; an expired marker is removed. Caller holds requestMu.
+func rejectedRecently(store *database.Store, gid string, cooldown time.Duration) (time.Duration, bool) {
+	raw, found, _ := store.Get(keyPrefixRejected + gid)
+	if !found {
+		return 0, false
+	}
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		if left := cooldown - time.Since(t); left > 0 {
+			return left, true
+		}
+	}
+	_ = store.Delete(keyPrefixRejected + gid)
+	return 0, false
+}
+
+func loadRequestIndex(store *database.Store) []string {
+	raw, found, _ := store.Get(keyRequestIndex)
+	var ids []string
+	if !found || json.Unmarshal([]byte(raw), &ids) != nil {
+		return nil
+	}
+	return ids
+}
+
+func saveRequestIndex(store *database.Store, ids []string) error {
+	if len(ids) == 0 {
+		return store.Delete(keyRequestIndex)
+	}
+	raw, err := json.Marshal(ids)
+	if err != nil {
+		return err
+	}
+	return store.Set(keyRequestIndex, string(raw))
+}
+
+// handleRequestCommand handles requestWhitelist <reason> from a group admin in a non-whitelisted group.
+// It runs as a chain handler and, for groups a Policy drops, directly from a global Policy.Middleware (e.g. New).
+func handleRequestCommand(ctx protocol.Context) {
+	text := strings.TrimSpace(ctx.PlainText())
+	parts := strings.Fields(text)
+	prefix := ctx.CommandPrefix()
+	if len(parts) == 0 || parts[0] != prefix+cmdRequest {
+		return
+	}
+	gid := ctx.GroupID()
+	if gid == "" || gid == "0" || !(ctx.IsAdmin() || ctx.IsSuperAdmin()) {
+		return
+	}
+	storeMu.RLock()
+	s := store
+	storeMu.RUnlock()
+	if s == nil {
+		_ = ctx.SendPlainMessage("whitelist store 未初始化")
+		return
+	}
+	reason := strings.TrimSpace(strings.TrimPrefix(text, parts[0]))
+	if reason == "" {
+		_ = ctx.SendPlainMessage("用法: " + prefix + cmdRequest + " <申请理由>")
+		return
+	}
+	if utf8.RuneCountInString(reason) > maxReasonLen {
+		_ = ctx.SendPlainMessage("申请理由太长了，请控制在 200 字以内")
+		return
+	}
+	if HasGroup(s, gid) {
+		_ = ctx.SendPlainMessage("本群已在白名单中")
+		return
+	}
+	if IsGroupDenied(s, gid) {
+		return
+	}
+	n, admins, ttl := currentNotifier()
+	requestMu.Lock()
+	if left, rejected := rejectedRecently(s, gid, currentRejectCooldown()); rejected {
+		requestMu.Unlock()
+		_ = ctx.SendPlainMessage("本群的白名单申请刚被拒绝，请 " + left.Round(time.Minute).String() + " 后再申请")
+		return
+	}
+	if _, pending := loadRequest(s, gid, ttl); pending {
+		requestMu.Unlock()
+		_ = ctx.SendPlainMessage("本群已有待处理的白名单申请，请耐心等待")
+		return
+	}
+	r := WhitelistRequest{
+		GroupID:   gid,
+		UserID:    ctx.UserID(),
+		Nickname:  ctx.SenderNickname(),
+		Reason:    reason,
+		CreatedAt: time.Now().Format(time.RFC3339),
+	}
+	err := saveRequest(s, r)
+	requestMu.Unlock()
+	if err != nil {
+		_ = ctx.SendPlainMessage("提交白名单申请失败")
+		return
+	}
+	if n != nil {
+		msg := formatRequest(r) + "\n处理: " + prefix + cmdApprove + " " + gid + " 或 " + prefix + cmdReject + " " + gid + " [理由]"
+		for _, uid := range admins {
+			_ = n.NotifyUser(uid, msg)
+		}
+	}
+	_ = ctx.SendPlainMessage("已提交白名单申请，超级管理员处理后会通知本群（" + ttl.String() + " 内未处理将自动失效）")
+}
+
+// handleRequestAdminCommands handles approveWhitelist, rejectWhitelist and listWhitelistRequests (super admin only).
+func handleRequestAdminCommands(ctx protocol.Context) {
+	text := strings.TrimSpace(ctx.PlainText())
+	parts := strings.Fields(text)
+	if len(parts) == 0 {
+		return
+	}
+	prefix := ctx.CommandPrefix()
+	cmd, ok := strings.CutPrefix(parts[0], prefix)
+	if !ok || (cmd != cmdApprove && cmd != cmdReject && cmd != cmdListRequests) {
+		return
+	}
+	storeMu.RLock()
+	s := store
+	storeMu.RUnlock()
+	if s == nil {
+		_ = ctx.SendPlainMessage("whitelist store 未初始化")
+		return
+	}
+	if cmd == cmdListRequests {
+		reqs := PendingRequests(s)
+		if len(reqs) == 0 {
+			_ = ctx.SendPlainMessage("没有待处理的白名单申请")
+			return
+		}
+		blocks := make([]string, 0, len(reqs))
+		for _, r := range reqs {
+			blocks = append(blocks, formatRequest(r))
+		}
+		_ = ctx.SendPlainMessage(strings.Join(blocks, "\n\n"))
+		return
+	}
+	if len(parts) < 2 {
+		_ = ctx.SendPlainMessage("用法: " + prefix + cmd + " <群号>")
+		return
+	}
+	gid := parts[1]
+	n, _, ttl := currentNotifier()
+	// The request is removed only once it has been handled, so a failed approval leaves it pending.
+	requestMu.Lock()
+	r, pending := loadRequest(s, gid, ttl)
+	if !pending {
+		requestMu.Unlock()
+		_ = ctx.SendPlainMessage("群 " + gid + " 没有待处理的白名单申请")
+		return
+	}
+	if cmd == cmdApprove {
+		err := AddGroup(s, gid, ctx.UserID())
+		if err == nil {
+			removeRequest(s, gid)
+		}
+		requestMu.Unlock()
+		if err != nil {
+			_ = ctx.SendPlainMessage("添加群白名单失败，申请仍保留")
+			return
+		}
+		if n != nil {
+			_ = n.NotifyGroup(gid, "本群的白名单申请已通过")
+		}
+		_ = ctx.SendPlainMessage("已通过群 " + gid + " 的白名单申请")
+		return
+	}
+	removeRequest(s, gid)
+	_ = s.Set(keyPrefixRejected+gid, time.Now().Format(time.RFC3339))
+	requestMu.Unlock()
+	reason := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(strings.TrimPrefix(text, parts[0])), gid))
+	audit(s, ctx.UserID(), auditRejectRequest, gid, reason)
+	msg := "本群的白名单申请未通过"
+	if reason != "" {
+		msg += "：" + reason
+	}
+	if n != nil {
+		_ = n.NotifyGroup(gid, msg)
+	}
+	_ = ctx.SendPlainMessage("已拒绝群 " + r.GroupID + " 的白名单申请")
+}
+
+func formatRequest(r WhitelistRequest) string {
+	at := r.CreatedAt
+	if t, err := time.Parse(time.RFC3339, r.CreatedAt); err == nil {
+		at = t.Format("01-02 15:04")
+	}
+	return "群 " + r.GroupID + " 申请加入白名单\n申请人：" + r.Nickname + "（" + r.UserID + "）\n时间：" + at + "\n理由：" + r.Reason
+}
@@ -0,0 +1,139 @@
+package whitelist
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Hafuunano/Protocol-ConvertTool/protocol"
+)
+
+// fakeContext implements the parts of protocol.Context the request handlers read; calling anything else panics.
+type fakeContext struct {
+	protocol.Context
+	text, user, group string
+	admin, superAdmin bool
+	sent              []string
+}
+
+func (c *fakeContext) PlainText() string      { return c.text }
+func (c *fakeContext) UserID() string         { return c.user }
+func (c *fakeContext) GroupID() string        { return c.group }
+func (c *fakeContext) SenderNickname() string { return "nick" + c.user }
+func (c *fakeContext) IsAdmin() bool          { return c.admin }
+func (c *fakeContext) IsSuperAdmin() bool     { return c.superAdmin }
+func (c *fakeContext) CommandPrefix() string  { return "/" }
+func (c *fakeContext) SendPlainMessage(text string) error {
+	c.sent = append(c.sent, text)
+	return nil
+}
+
+func (c *fakeContext) last() string {
+	if len(c.sent) == 0 {
+		return ""
+	}
+	return c.sent[len(c.sent)-1]
+}
+
+type fakeNotifier struct {
+	mu     sync.Mutex
+	users  []string
+	groups []string
+}
+
+func (n *fakeNotifier) NotifyUser(userID, text string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.users = append(n.users, userID)
+	return nil
+}
+
+func (n *fakeNotifier) NotifyGroup(groupID, text string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.groups = append(n.groups, groupID+": "+text)
+	return nil
+}
+
+// useRequestStore installs s and a fake notifier for the request handlers and restores the previous ones afterwards.
+func useRequestStore(t *testing.T) *fakeNotifier {
+	s := newTestStore(t)
+	storeMu.Lock()
+	prev := store
+	store = s
+	storeMu.Unlock()
+	n := &fakeNotifier{}
+	SetNotifier(n, []string{"900", "901"})
+	t.Cleanup(func() {
+		SetNotifier(nil, nil)
+		SetRejectCooldown(0)
+		storeMu.Lock()
+		store = prev
+		storeMu.Unlock()
+	})
+	return n
+}
+
+func request(gid string) *fakeContext {
+	return &fakeContext{text: "/" + cmdRequest + " 想用机器人", user: "100", group: gid, admin: true}
+}
+
+func superAdminCommand(text string) *fakeContext {
+	return &fakeContext{text: text, user: "900", superAdmin: true}
+}
+
+func TestRejectedGroupCannotResubmitDuringCooldown(t *testing.T) {
+	n := useRequestStore(t)
+	const gid = "50001"
+	handleRequestCommand(request(gid))
+	if len(n.users) != 2 {
+		t.Fatalf("super admins notified %d times, want 2", len(n.users))
+	}
+	handleRequestAdminCommands(superAdminCommand("/" + cmdReject + " " + gid + " 不合适"))
+	if len(PendingRequests(store)) != 0 {
+		t.Fatal("rejected request still pending")
+	}
+
+	again := request(gid)
+	handleRequestCommand(again)
+	if !strings.Contains(again.last(), "刚被拒绝") {
+		t.Fatalf("resubmission reply = %q, want the cooldown notice", again.last())
+	}
+	if len(n.users) != 2 || len(PendingRequests(store)) != 0 {
+		t.Fatalf("resubmission during cooldown notified %d times and queued %d requests", len(n.users)-2, len(PendingRequests(store)))
+	}
+
+	// Once the cooldown has passed the group may ask again.
+	_ = store.Set(keyPrefixRejected+gid, time.Now().Add(-25*time.Hour).Format(time.RFC3339))
+	later := request(gid)
+	handleRequestCommand(later)
+	if len(n.users) != 4 || len(PendingRequests(store)) != 1 {
+		t.Fatalf("after the cooldown: reply %q, %d notifications, %d pending", later.last(), len(n.users), len(PendingRequests(store)))
+	}
+	if _, found, _ := store.Get(keyPrefixRejected + gid); found {
+		t.Error("expired rejection marker kept")
+	}
+}
+
+func TestApproveRemovesRequestAfterAddingGroup(t *testing.T) {
+	n := useRequestStore(t)
+	const gid = "50002"
+	handleRequestCommand(request(gid))
+	approve := superAdminCommand("/" + cmdApprove + " " + gid)
+	handleRequestAdminCommands(approve)
+	if !HasGroup(store, gid) {
+		t.Fatalf("group not whitelisted; reply %q", approve.last())
+	}
+	if len(PendingRequests(store)) != 0 {
+		t.Fatal("approved request still pending")
+	}
+	if len(n.groups) != 1 || !strings.Contains(n.groups[0], "已通过") {
+		t.Fatalf("group notifications = %v", n.groups)
+	}
+	again := superAdminCommand("/" + cmdApprove + " " + gid)
+	handleRequestAdminCommands(again)
+	if !strings.Contains(again.last(), "没有待处理") {
+		t.Fatalf("second approval reply = %q", again.last())
+	}
+}
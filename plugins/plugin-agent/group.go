@@ -0,0 +1,213 @@
+package pluginagent
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Hafuunano/Protocol-ConvertTool/protocol"
+)
+
+const (
+	keyPrefixGroup = "pluginAgent:group:"
+	// Per-group keys: pluginAgent:group:<gid>:<field>.
+	groupFieldEnabled      = "enabled"
+	groupFieldPromptSuffix = "promptSuffix"
+	groupFieldScope        = "scope"
+	groupFieldMaxReplyLen  = "maxReplyLen"
+	maxReplyLenLimit       = 4000
+)
+
+// Session scopes: whose conversation history a message joins.
+const (
+	scopeUser   = "user"   // one session per user across all groups (default)
+	scopeGroup  = "group"  // one session shared by the whole group
+	scopeMember = "member" // one session per user within this group
+)
+
+// Group admin commands (also usable by super admin), handled in the current group.
+const (
+	cmdAgentOn     = "agentOn"
+	cmdAgentOff    = "agentOff"
+	cmdAgentPrompt = "agentPrompt"
+	cmdAgentScope  = "agentScope"
+	cmdAgentMaxLen = "agentMaxLen"
+	cmdAgentStatus = "agentStatus"
+)
+
+var groupCommands = []string{cmdAgentOn, cmdAgentOff, cmdAgentPrompt, cmdAgentScope, cmdAgentMaxLen, cmdAgentStatus}
+
+// groupSettings is the per-group agent configuration; zero-config groups get defaultGroupSettings.
+type groupSettings struct {
+	Enabled      bool
+	PromptSuffix string
+	Scope        string
+	MaxReplyLen  int // in runes; 0 means no limit
+}
+
+func defaultGroupSettings() groupSettings {
+	return groupSettings{Enabled: true, Scope: scopeUser}
+}
+
+func groupKey(gid, field string) string {
+	return keyPrefixGroup + gid + ":" + field
+}
+
+func isPrivateChat(gid string) bool {
+	return gid == "" || gid == "0"
+}
+
+// loadGroupSettings returns the settings for gid from store; private chat and missing keys use defaults.
+func loadGroupSettings(gid string) groupSettings {
+	gs := defaultGroupSettings()
+	s := getStore()
+	if s == nil || isPrivateChat(gid) {
+		return gs
+	}
+	if v, found, _ := s.Get(groupKey(gid, groupFieldEnabled)); found {
+		gs.Enabled = v != "0"
+	}
+	if v, found, _ := s.Get(groupKey(gid, groupFieldPromptSuffix)); found {
+		gs.PromptSuffix = v
+	}
+	if v, found, _ := s.Get(groupKey(gid, groupFieldScope)); found && isValidScope(v) {
+		gs.Scope = v
+	}
+	if v, found, _ := s.Get(groupKey(gid, groupFieldMaxReplyLen)); found {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			gs.MaxReplyLen = n
+		}
+	}
+	return gs
+}
+
+func isValidScope(scope string) bool {
+	return scope == scopeUser || scope == scopeGroup || scope == scopeMember
+}
+
+// sessionKey returns the session key for the sender under the group's scope. Private chat always uses the user ID.
+func sessionKey(ctx protocol.Context, gs groupSettings) string {
+	uid, gid := ctx.UserID(), ctx.GroupID()
+	if isPrivateChat(gid) {
+		return uid
+	}
+	switch gs.Scope {
+	case scopeGroup:
+		return "group_" + gid
+	case scopeMember:
+		return "group_" + gid + "_" + uid
+	default:
+		return uid
+	}
+}
+
+// systemPromptFor returns the system prompt with the group's suffix, reply length hint and guard instructions appended.
+func systemPromptFor(gs groupSettings) string {
+	prompt := systemPrompt
+	if gs.PromptSuffix != "" {
+		prompt += "\n\n" + gs.PromptSuffix
+	}
+	if gs.MaxReplyLen > 0 {
+		prompt += "\n\n请将每次回复控制在 " + strconv.Itoa(gs.MaxReplyLen) + " 字以内。"
+	}
+	return prompt + "\n\n" + guardInstructions()
+}
+
+// truncateReply cuts reply to maxLen runes (0 means no limit), marking the cut with an ellipsis.
+func truncateReply(reply string, maxLen int) string {
+	if maxLen <= 0 || utf8.RuneCountInString(reply) <= maxLen {
+		return reply
+	}
+	r := []rune(reply)
+	return string(r[:maxLen]) + "…"
+}
+
+// isGroupCommand returns true if plain text is one of the agent group commands.
+func isGroupCommand(text string) bool {
+	for _, cmd := range groupCommands {
+		if hasCommandPrefix(text, cmd) {
+			return true
+		}
+	}
+	return false
+}
+
+func replyText(ctx protocol.Context, text string) {
+	_ = ctx.Reply(protocol.Message{
+		protocol.Segment{Type: protocol.SegmentTypeText, Data: map[string]any{"text": text}},
+	})
+}
+
+// handleGroupCommand handles agentOn/agentOff/agentPrompt/agentScope/agentMaxLen/agentStatus for the current group (group admin or super admin).
+func handleGroupCommand(ctx protocol.Context) {
+	raw := strings.TrimSpace(ctx.PlainText())
+	if !isGroupCommand(raw) {
+		return
+	}
+	gid := ctx.GroupID()
+	if isPrivateChat(gid) || (!ctx.IsAdmin() && !ctx.IsSuperAdmin()) {
+		return
+	}
+	s := getStore()
+	if s == nil {
+		replyText(ctx, "plugin-agent 未初始化 store")
+		return
+	}
+	switch {
+	case hasCommandPrefix(raw, cmdAgentOn):
+		_ = s.Set(groupKey(gid, groupFieldEnabled), "1")
+		replyText(ctx, "已在本群开启 AI 对话")
+	case hasCommandPrefix(raw, cmdAgentOff):
+		_ = s.Set(groupKey(gid, groupFieldEnabled), "0")
+		replyText(ctx, "已在本群关闭 AI 对话")
+	case hasCommandPrefix(raw, cmdAgentPrompt):
+		val := getCommandArg(ctx, cmdAgentPrompt)
+		switch val {
+		case "":
+			replyText(ctx, "用法: /agentPrompt <追加到人设后的提示词>，/agentPrompt clear 清除")
+		case "clear", "清除":
+			_ = s.Delete(groupKey(gid, groupFieldPromptSuffix))
+			replyText(ctx, "已清除本群附加提示词")
+		default:
+			_ = s.Set(groupKey(gid, groupFieldPromptSuffix), val)
+			replyText(ctx, "已设置本群附加提示词")
+		}
+	case hasCommandPrefix(raw, cmdAgentScope):
+		val := getCommandArg(ctx, cmdAgentScope)
+		if !isValidScope(val) {
+			replyText(ctx, "用法: /agentScope <user|group|member>\nuser: 每人一份对话（跨群共享）\ngroup: 全群共享一份对话\nmember: 每人在本群单独一份对话")
+			return
+		}
+		_ = s.Set(groupKey(gid, groupFieldScope), val)
+		replyText(ctx, "已设置本群会话范围: "+val)
+	case hasCommandPrefix(raw, cmdAgentMaxLen):
+		n, err := strconv.Atoi(getCommandArg(ctx, cmdAgentMaxLen))
+		if err != nil || n < 0 || n > maxReplyLenLimit {
+			replyText(ctx, "用法: /agentMaxLen <0-"+strconv.Itoa(maxReplyLenLimit)+">，0 表示不限制")
+			return
+		}
+		_ = s.Set(groupKey(gid, groupFieldMaxReplyLen), strconv.Itoa(n))
+		replyText(ctx, "已设置本群回复长度上限: "+strconv.Itoa(n))
+	case hasCommandPrefix(raw, cmdAgentStatus):
+		replyText(ctx, formatGroupStatus(loadGroupSettings(gid)))
+	}
+}
+
+func formatGroupStatus(gs groupSettings) string {
+	enabled := "开启"
+	if !gs.Enabled {
+		enabled = "关闭"
+	}
+	suffix := gs.PromptSuffix
+	if suffix == "" {
+		suffix = "(无)"
+	}
+	maxLen := "不限制"
+	if gs.MaxReplyLen > 0 {
+		maxLen = strconv.Itoa(gs.MaxReplyLen)
+	}
+	return "AI 对话: " + enabled + "\n" +
+		"会话范围: " + gs.Scope + "\n" +
+		"回复长度上限: " + maxLen + "\n" +
+		"附加提示词: " + suffix
+}
@@ -1,5 +1,7 @@
 // Package pluginagent provides an LLM chat plugin: reads soul/PERSONA as system prompt,
 // per-user session with max 10 context turns; when exceeded, summarizes and starts a new round.
+// Group admins can enable/disable the agent and tune prompt suffix, session scope and reply length per group.
+// User content is delimited and replies leaking the persona or the canary are blocked (see guard.go).
 package pluginagent
 
 import (
@@ -7,7 +9,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -127,6 +128,7 @@ type chatResp struct {
 			Role    string          `json:"role"`
 		} `json:"message"`
 	} `json:"choices"`
+	Usage llmUsage `json:"usage"`
 }
 
 func init() {
@@ -135,8 +137,12 @@ func init() {
 	llmConfig.Key = ""
 	llmConfig.Model = defaultModel
 	loadLLMConfigFromStore()
-	// Super admin only: /setLLMUrl, /setLLMKey, /setLLMModel (runs on HookMessage, so works without @)
+	initObserve()
+	initGuard()
+	// Super admin only: /setLLMUrl, /setLLMKey, /setLLMModel, /setLLMCanary (runs on HookMessage, so works without @)
 	p.OnMessage().IsOnlySuperAdmin().Func(handleSuperAdminCommand)
+	// Group admin or super admin: /agentOn, /agentOff, /agentPrompt, /agentScope, /agentMaxLen, /agentStatus for the current group.
+	p.OnMessage().Func(handleGroupCommand)
 	// When @bot or reply: host dispatches HookMessageReply only; OnMessage().IsOnlyToMe() is on HookMessage so never runs. Use OnMessageReply().
 	p.OnMessageReply().Func(handleOnlyToMe)
 }
@@ -155,15 +161,15 @@ func getCommandArg(ctx protocol.Context, cmd string) string {
 	return ""
 }
 
-// isSetLLMCommand returns true if plain text is one of /setLLMUrl, /setLLMKey, /setLLMModel.
+// isSetLLMCommand returns true if plain text is one of /setLLMUrl, /setLLMKey, /setLLMModel, /setLLMCanary.
 func isSetLLMCommand(text string) bool {
 	text = strings.TrimSpace(text)
 	for _, prefix := range []string{"/", "!", "！", "."} {
-		if strings.HasPrefix(text, prefix+"setLLMUrl") || strings.HasPrefix(text, prefix+"setLLMKey") || strings.HasPrefix(text, prefix+"setLLMModel") {
+		if strings.HasPrefix(text, prefix+"setLLMUrl") || strings.HasPrefix(text, prefix+"setLLMKey") || strings.HasPrefix(text, prefix+"setLLMModel") || strings.HasPrefix(text, prefix+"setLLMCanary") {
 			return true
 		}
 	}
-	return strings.HasPrefix(text, "setLLMUrl") || strings.HasPrefix(text, "setLLMKey") || strings.HasPrefix(text, "setLLMModel")
+	return strings.HasPrefix(text, "setLLMUrl") || strings.HasPrefix(text, "setLLMKey") || strings.HasPrefix(text, "setLLMModel") || strings.HasPrefix(text, "setLLMCanary")
 }
 
 // hasCommandPrefix returns true if plain text starts with prefix+cmd (e.g. /setLLMUrl).
@@ -239,11 +245,28 @@ func handleSuperAdminCommand(ctx protocol.Context) {
 		})
 		return
 	}
+	val = getCommandArg(ctx, "setLLMCanary")
+	if hasCommandPrefix(raw, "setLLMCanary") {
+		if val == "" {
+			_ = ctx.Reply(protocol.Message{
+				protocol.Segment{Type: protocol.SegmentTypeText, Data: map[string]any{"text": "用法: /setLLMCanary <随机字符串>，回复中出现该字符串时会被拦截"}},
+			})
+			return
+		}
+		setCanary(val)
+		if s := getStore(); s != nil {
+			_ = s.Set(keyLLMCanary, strings.TrimSpace(val))
+		}
+		_ = ctx.Reply(protocol.Message{
+			protocol.Segment{Type: protocol.SegmentTypeText, Data: map[string]any{"text": "已设置 canary（已隐藏）"}},
+		})
+		return
+	}
 }
 
 func handleOnlyToMe(ctx protocol.Context) {
 	text := strings.TrimSpace(ctx.PlainText())
-	log.Printf("[plugin-agent] handleOnlyToMe: %s", text)
+	getLogger().Debug("message to bot", "user_id", ctx.UserID(), "group_id", ctx.GroupID(), textAttr("text", text))
 	if text == "" {
 		return
 	}
@@ -254,7 +277,16 @@ func handleOnlyToMe(ctx protocol.Context) {
 		}
 		return
 	}
-	handleChat(ctx)
+	// agent* group commands: group admin or super admin; same delegation as setLLM* above.
+	if isGroupCommand(text) {
+		handleGroupCommand(ctx)
+		return
+	}
+	gs := loadGroupSettings(ctx.GroupID())
+	if !gs.Enabled {
+		return
+	}
+	handleChat(ctx, gs)
 }
 
 func loadPersona() {
@@ -271,10 +303,12 @@ func loadPersona() {
 	data, err := os.ReadFile(abs)
 	if err != nil {
 		systemPrompt = "You are a helpful assistant."
+		setPersonaShingles("")
 		return
 	}
 	persona := strings.TrimSpace(string(data))
 	systemPrompt = persona + "\n\n我希望你扮演我所描述的人物"
+	setPersonaShingles(persona)
 }
 
 func sessionFilePath(key string) string {
@@ -350,45 +384,60 @@ func stripCQAt(s string) string {
 	return strings.TrimSpace(cqAtRegex.ReplaceAllString(s, ""))
 }
 
-func handleChat(ctx protocol.Context) {
+func handleChat(ctx protocol.Context, gs groupSettings) {
 	raw := ctx.PlainText()
 	text := stripCQAt(raw)
 	if text == "" {
 		return
 	}
-	key := ctx.UserID()
+	if gs.Scope == scopeGroup && !isPrivateChat(ctx.GroupID()) {
+		// Shared group session: tell the model who is speaking.
+		text = ctx.SenderNickname() + ": " + text
+	}
+	key := sessionKey(ctx, gs)
+	prompt := systemPromptFor(gs)
+	info := callInfo{Kind: callKindChat, UserID: ctx.UserID(), GroupID: ctx.GroupID()}
 	s := getOrCreateSession(key)
 	s.Mu.Lock()
 	defer s.Mu.Unlock()
 
-	s.Messages = append(s.Messages, chatMessage{Role: "user", Content: text})
+	s.Messages = append(s.Messages, chatMessage{Role: "user", Content: wrapUserContent(text)})
 
-	messages := buildMessages(s)
+	messages := buildMessages(s, prompt)
 	if len(s.Messages) > maxContextTurns*2 {
-		summary, err := summarizeConversation(messages)
+		summary, err := summarizeConversation(callInfo{Kind: callKindSummary, UserID: info.UserID, GroupID: info.GroupID}, messages)
 		if err == nil && summary != "" {
 			s.LatestSummary = summary
 			s.Messages = []chatMessage{
 				{Role: "user", Content: "[Previous conversation summary]\n" + summary},
 				{Role: "assistant", Content: "好的，我记住了之前的对话要点，我们继续聊吧～"},
 			}
-			s.Messages = append(s.Messages, chatMessage{Role: "user", Content: text})
-			messages = buildMessages(s)
+			s.Messages = append(s.Messages, chatMessage{Role: "user", Content: wrapUserContent(text)})
+			messages = buildMessages(s, prompt)
 			saveSession(key, s)
 		} else {
 			trimToLastNTurns(s, maxContextTurns)
-			messages = buildMessages(s)
+			messages = buildMessages(s, prompt)
 		}
 	}
 
-	reply, err := callLLM(messages)
+	reply, err := callLLM(info, messages)
 	if err != nil {
-		log.Printf("[plugin-agent] callLLM error: %v", err)
 		_ = ctx.Reply(protocol.Message{
 			protocol.Segment{Type: protocol.SegmentTypeText, Data: map[string]any{"text": "呜…出错了: " + err.Error()}},
 		})
 		return
 	}
+	if reason, blocked := scanReply(reply); blocked {
+		observeBlocked(info, reason, text)
+		// Drop the offending turn so it does not stay in the context.
+		s.Messages = s.Messages[:len(s.Messages)-1]
+		_ = ctx.Reply(protocol.Message{
+			protocol.Segment{Type: protocol.SegmentTypeText, Data: map[string]any{"text": blockedReply}},
+		})
+		return
+	}
+	reply = truncateReply(reply, gs.MaxReplyLen)
 	s.Messages = append(s.Messages, chatMessage{Role: "assistant", Content: reply})
 	saveSession(key, s)
 	_ = ctx.Reply(protocol.Message{
@@ -396,9 +445,9 @@ func handleChat(ctx protocol.Context) {
 	})
 }
 
-func buildMessages(s *userSession) []chatMessage {
+func buildMessages(s *userSession, prompt string) []chatMessage {
 	out := make([]chatMessage, 0, len(s.Messages)+1)
-	out = append(out, chatMessage{Role: "system", Content: systemPrompt})
+	out = append(out, chatMessage{Role: "system", Content: prompt})
 	out = append(out, s.Messages...)
 	return out
 }
@@ -411,7 +460,7 @@ func trimToLastNTurns(s *userSession, n int) {
 	s.Messages = s.Messages[len(s.Messages)-total:]
 }
 
-func summarizeConversation(messages []chatMessage) (string, error) {
+func summarizeConversation(info callInfo, messages []chatMessage) (string, error) {
 	if len(messages) <= 2 {
 		return "", nil
 	}
@@ -422,22 +471,30 @@ func summarizeConversation(messages []chatMessage) (string, error) {
 		Content: "Please summarize the following conversation in 1-3 short paragraphs in the same language, preserving key facts and tone. Output only the summary.",
 	})
 	sumReq = append(sumReq, toSum...)
-	return callLLM(sumReq)
+	return callLLM(info, sumReq)
 }
 
-func callLLM(messages []chatMessage) (string, error) {
+// callLLM sends one chat completion request and records it via observeCall.
+func callLLM(info callInfo, messages []chatMessage) (string, error) {
 	llmConfigMu.RLock()
 	baseURL, apiKey, model := llmConfig.URL, llmConfig.Key, llmConfig.Model
 	llmConfigMu.RUnlock()
+	start := time.Now()
+	reply, usage, err := doChatCompletion(baseURL, apiKey, model, messages)
+	observeCall(info, model, time.Since(start), usage, err)
+	return reply, err
+}
+
+func doChatCompletion(baseURL, apiKey, model string, messages []chatMessage) (string, llmUsage, error) {
 	url := strings.TrimSuffix(baseURL, "/") + "/chat/completions"
 	body := chatReq{Model: model, Messages: messages}
 	raw, err := json.Marshal(body)
 	if err != nil {
-		return "", err
+		return "", llmUsage{}, err
 	}
 	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(raw))
 	if err != nil {
-		return "", err
+		return "", llmUsage{}, err
 	}
 	req.Header.Set("Content-Type", "application/json")
 	if apiKey != "" {
@@ -446,24 +503,25 @@ func callLLM(messages []chatMessage) (string, error) {
 	client := &http.Client{Timeout: 60 * time.Second}
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", err
+		return "", llmUsage{}, err
 	}
 	defer resp.Body.Close()
 	data, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", err
+		return "", llmUsage{}, err
 	}
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("API %d: %s", resp.StatusCode, string(data))
+		return "", llmUsage{}, fmt.Errorf("API %d: %s", resp.StatusCode, string(data))
 	}
 	var r chatResp
 	if err := json.Unmarshal(data, &r); err != nil {
-		return "", err
+		return "", llmUsage{}, err
 	}
 	if len(r.Choices) == 0 {
-		return "", fmt.Errorf("no choices in response")
+		return "", r.Usage, fmt.Errorf("no choices in response")
 	}
-	return extractContent(r.Choices[0].Message.Content)
+	reply, err := extractContent(r.Choices[0].Message.Content)
+	return reply, r.Usage, err
 }
 
 // extractContent supports content as string or array of {type, text} (OpenAI/Moonshot compatible).
@@ -0,0 +1,337 @@
+package pluginagent
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Hafuunano/Plugin-Collections/middlewares/logging"
+)
+
+const (
+	logBodiesEnv   = "PLUGIN_AGENT_LOG_BODIES"
+	metricsAddrEnv = "PLUGIN_AGENT_METRICS_ADDR"
+	metricsPrefix  = "plugin_agent_llm_"
+)
+
+// Call kinds and outcomes used as log fields and metric labels.
+const (
+	callKindChat    = "chat"
+	callKindSummary = "summary"
+	outcomeOK       = "ok"
+	outcomeError    = "error"
+	outcomeBlocked  = "blocked"
+)
+
+// latencyBuckets are the upper bounds (seconds) of the LLM latency histogram.
+var latencyBuckets = []float64{0.25, 0.5, 1, 2, 5, 10, 20, 30, 60}
+
+var (
+	loggerMu  sync.RWMutex
+	logger    *slog.Logger
+	logBodies atomic.Bool
+	metrics   = newMetricsRegistry()
+)
+
+// callInfo identifies one LLM call for logging and metrics.
+type callInfo struct {
+	Kind    string
+	UserID  string
+	GroupID string
+}
+
+// llmUsage is the OpenAI-compatible "usage" object of a chat completion response.
+type llmUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// SetLogger sets the slog logger used for per-call events. Nil restores slog.Default().
+func SetLogger(l *slog.Logger) {
+	loggerMu.Lock()
+	defer loggerMu.Unlock()
+	logger = l
+}
+
+// SetLogMessageBodies enables logging of user messages in plaintext. Off by default: only length and a short hash are logged.
+func SetLogMessageBodies(on bool) {
+	logBodies.Store(on)
+}
+
+func getLogger() *slog.Logger {
+	loggerMu.RLock()
+	l := logger
+	loggerMu.RUnlock()
+	if l == nil {
+		l = slog.Default()
+	}
+	return l.With("plugin", Meta.PluginName)
+}
+
+func initObserve() {
+	if v, err := strconv.ParseBool(os.Getenv(logBodiesEnv)); err == nil {
+		SetLogMessageBodies(v)
+	}
+	if addr := strings.TrimSpace(os.Getenv(metricsAddrEnv)); addr != "" {
+		if err := ServeMetrics(addr); err != nil {
+			getLogger().Error("metrics endpoint failed", "addr", addr, "err", err)
+		}
+	}
+}
+
+// textAttr returns the message text as a log attribute, redacted like the logging middleware (rune length and keyed hash)
+// unless SetLogMessageBodies(true).
+func textAttr(key, text string) slog.Attr {
+	if logBodies.Load() {
+		return slog.String(key, text)
+	}
+	return logging.RedactedText(key, text)
+}
+
+// observeCall logs one LLM call and records it in the metrics registry.
+func observeCall(info callInfo, model string, latency time.Duration, usage llmUsage, err error) {
+	outcome := outcomeOK
+	if err != nil {
+		outcome = outcomeError
+	}
+	metrics.record(info.Kind, outcome, latency, usage)
+	attrs := []any{
+		"kind", info.Kind,
+		"user_id", info.UserID,
+		"group_id", info.GroupID,
+		"model", model,
+		"latency_ms", latency.Milliseconds(),
+		"prompt_tokens", usage.PromptTokens,
+		"completion_tokens", usage.CompletionTokens,
+		"total_tokens", usage.TotalTokens,
+		"outcome", outcome,
+	}
+	if err != nil {
+		getLogger().Warn("llm call", append(attrs, "err", err)...)
+		return
+	}
+	getLogger().Info("llm call", attrs...)
+}
+
+// observeBlocked logs a reply withheld by the guard and counts it as a blocked call.
+func observeBlocked(info callInfo, reason, userText string) {
+	metrics.recordBlocked(info.Kind, reason)
+	getLogger().Warn("llm reply blocked",
+		"kind", info.Kind,
+		"user_id", info.UserID,
+		"group_id", info.GroupID,
+		"reason", reason,
+		"outcome", outcomeBlocked,
+		textAttr("text", userText),
+	)
+}
+
+// histogram is a fixed-bucket latency histogram; counts are per bucket (not cumulative).
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+type callKey struct {
+	kind    string
+	outcome string
+}
+
+type tokenCount struct {
+	prompt     uint64
+	completion uint64
+}
+
+type metricsRegistry struct {
+	mu      sync.Mutex
+	calls   map[callKey]uint64
+	blocked map[string]uint64
+	tokens  map[string]*tokenCount
+	latency map[string]*histogram
+}
+
+func newMetricsRegistry() *metricsRegistry {
+	return &metricsRegistry{
+		calls:   make(map[callKey]uint64),
+		blocked: make(map[string]uint64),
+		tokens:  make(map[string]*tokenCount),
+		latency: make(map[string]*histogram),
+	}
+}
+
+func (m *metricsRegistry) record(kind, outcome string, latency time.Duration, usage llmUsage) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls[callKey{kind: kind, outcome: outcome}]++
+	t := m.tokens[kind]
+	if t == nil {
+		t = &tokenCount{}
+		m.tokens[kind] = t
+	}
+	t.prompt += uint64(max(usage.PromptTokens, 0))
+	t.completion += uint64(max(usage.CompletionTokens, 0))
+	h := m.latency[kind]
+	if h == nil {
+		h = &histogram{counts: make([]uint64, len(latencyBuckets))}
+		m.latency[kind] = h
+	}
+	sec := latency.Seconds()
+	for i, le := range latencyBuckets {
+		if sec <= le {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += sec
+	h.count++
+}
+
+func (m *metricsRegistry) recordBlocked(kind, reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls[callKey{kind: kind, outcome: outcomeBlocked}]++
+	m.blocked[reason]++
+}
+
+// CallStats is the number of LLM calls of one kind ("chat", "summary") with one outcome ("ok", "error", "blocked").
+// A blocked call is also counted as ok: the API succeeded but the guard withheld the reply.
+type CallStats struct {
+	Kind    string
+	Outcome string
+	Count   uint64
+}
+
+// TokenStats is the token usage reported by the API for one call kind.
+type TokenStats struct {
+	Kind             string
+	PromptTokens     uint64
+	CompletionTokens uint64
+}
+
+// LatencyStats is the latency histogram of one call kind. Counts[i] is cumulative (calls with latency <= Buckets[i] seconds).
+type LatencyStats struct {
+	Kind    string
+	Buckets []float64
+	Counts  []uint64
+	Sum     float64
+	Count   uint64
+}
+
+// GuardStats is the number of replies withheld by the guard for one reason ("canary", "persona_leak").
+type GuardStats struct {
+	Reason string
+	Count  uint64
+}
+
+// MetricsSnapshot is a point-in-time copy of the in-process LLM metrics.
+type MetricsSnapshot struct {
+	Calls   []CallStats
+	Blocked []GuardStats
+	Tokens  []TokenStats
+	Latency []LatencyStats
+}
+
+// Metrics returns a snapshot of the in-process LLM metrics, sorted by kind. Hosts can scrape it directly instead of the HTTP endpoint.
+func Metrics() MetricsSnapshot {
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	var snap MetricsSnapshot
+	for k, n := range metrics.calls {
+		snap.Calls = append(snap.Calls, CallStats{Kind: k.kind, Outcome: k.outcome, Count: n})
+	}
+	sort.Slice(snap.Calls, func(i, j int) bool {
+		if snap.Calls[i].Kind != snap.Calls[j].Kind {
+			return snap.Calls[i].Kind < snap.Calls[j].Kind
+		}
+		return snap.Calls[i].Outcome < snap.Calls[j].Outcome
+	})
+	for reason, n := range metrics.blocked {
+		snap.Blocked = append(snap.Blocked, GuardStats{Reason: reason, Count: n})
+	}
+	sort.Slice(snap.Blocked, func(i, j int) bool { return snap.Blocked[i].Reason < snap.Blocked[j].Reason })
+	for kind, t := range metrics.tokens {
+		snap.Tokens = append(snap.Tokens, TokenStats{Kind: kind, PromptTokens: t.prompt, CompletionTokens: t.completion})
+	}
+	sort.Slice(snap.Tokens, func(i, j int) bool { return snap.Tokens[i].Kind < snap.Tokens[j].Kind })
+	for kind, h := range metrics.latency {
+		ls := LatencyStats{Kind: kind, Buckets: append([]float64(nil), latencyBuckets...), Counts: make([]uint64, len(h.counts)), Sum: h.sum, Count: h.count}
+		var cum uint64
+		for i, c := range h.counts {
+			cum += c
+			ls.Counts[i] = cum
+		}
+		snap.Latency = append(snap.Latency, ls)
+	}
+	sort.Slice(snap.Latency, func(i, j int) bool { return snap.Latency[i].Kind < snap.Latency[j].Kind })
+	return snap
+}
+
+// WriteMetrics writes the current metrics to w in the Prometheus text exposition format.
+func WriteMetrics(w io.Writer) error {
+	snap := Metrics()
+	var b strings.Builder
+	b.WriteString("# HELP " + metricsPrefix + "calls_total LLM calls by kind and outcome.\n")
+	b.WriteString("# TYPE " + metricsPrefix + "calls_total counter\n")
+	for _, c := range snap.Calls {
+		fmt.Fprintf(&b, "%scalls_total{kind=%q,outcome=%q} %d\n", metricsPrefix, c.Kind, c.Outcome, c.Count)
+	}
+	b.WriteString("# HELP " + metricsPrefix + "guard_blocked_total Replies withheld by the prompt-injection guard.\n")
+	b.WriteString("# TYPE " + metricsPrefix + "guard_blocked_total counter\n")
+	for _, g := range snap.Blocked {
+		fmt.Fprintf(&b, "%sguard_blocked_total{reason=%q} %d\n", metricsPrefix, g.Reason, g.Count)
+	}
+	b.WriteString("# HELP " + metricsPrefix + "tokens_total Tokens reported by the LLM API.\n")
+	b.WriteString("# TYPE " + metricsPrefix + "tokens_total counter\n")
+	for _, t := range snap.Tokens {
+		fmt.Fprintf(&b, "%stokens_total{kind=%q,type=\"prompt\"} %d\n", metricsPrefix, t.Kind, t.PromptTokens)
+		fmt.Fprintf(&b, "%stokens_total{kind=%q,type=\"completion\"} %d\n", metricsPrefix, t.Kind, t.CompletionTokens)
+	}
+	b.WriteString("# HELP " + metricsPrefix + "latency_seconds LLM call latency.\n")
+	b.WriteString("# TYPE " + metricsPrefix + "latency_seconds histogram\n")
+	for _, l := range snap.Latency {
+		for i, le := range l.Buckets {
+			fmt.Fprintf(&b, "%slatency_seconds_bucket{kind=%q,le=%q} %d\n", metricsPrefix, l.Kind, strconv.FormatFloat(le, 'g', -1, 64), l.Counts[i])
+		}
+		fmt.Fprintf(&b, "%slatency_seconds_bucket{kind=%q,le=\"+Inf\"} %d\n", metricsPrefix, l.Kind, l.Count)
+		fmt.Fprintf(&b, "%slatency_seconds_sum{kind=%q} %g\n", metricsPrefix, l.Kind, l.Sum)
+		fmt.Fprintf(&b, "%slatency_seconds_count{kind=%q} %d\n", metricsPrefix, l.Kind, l.Count)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// MetricsHandler returns an http.Handler serving WriteMetrics output. Mount it on the host's own mux if it has one.
+func MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = WriteMetrics(w)
+	})
+}
+
+// ServeMetrics listens on addr and serves MetricsHandler at /metrics in the background. Also started from env PLUGIN_AGENT_METRICS_ADDR.
+func ServeMetrics(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", MetricsHandler())
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			getLogger().Error("metrics endpoint stopped", "addr", addr, "err", err)
+		}
+	}()
+	return nil
+}
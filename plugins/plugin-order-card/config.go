@@ -0,0 +1,305 @@
+package pluginordercard
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Hafuunano/Core-SkillAction/cache/database"
+	"github.com/Hafuunano/Protocol-ConvertTool/protocol"
+)
+
+const (
+	keyPrefixConfig  = "orderCard:config:"
+	defaultResetHour = 4
+	defaultTimezone  = "Asia/Shanghai"
+)
+
+// Config commands: group admin or super admin, in a registered group, operate on the current group.
+const (
+	cmdSetResetHour = "setOrderCardResetHour"
+	cmdSetTimezone  = "setOrderCardTimezone"
+	cmdSetSkipDays  = "setOrderCardSkipDays"
+	cmdSetBounds    = "setOrderCardBounds"
+	cmdSetMaxDelta  = "setOrderCardMaxDelta"
+	cmdSetMultiOp   = "setOrderCardMultiOp"
+	cmdSetCooldown  = "setOrderCardCooldown"
+	cmdSetPublic    = "setOrderCardPublic"
+	cmdSetAlias     = "setOrderCardAlias"
+	cmdShowConfig   = "orderCardConfig"
+)
+
+// GroupConfig is the per-group settings JSON stored at orderCard:config:{gid}. Missing fields keep defaultGroupConfig values.
+type GroupConfig struct {
+	ResetHour    int            `json:"resetHour"`
+	Timezone     string         `json:"timezone"`
+	SkipWeekdays []time.Weekday `json:"skipWeekdays,omitempty"` // no reset on these days
+
+	MinValue      int    `json:"minValue"`
+	MaxValue      int    `json:"maxValue"` // 0 means no upper bound
+	MaxDelta      int    `json:"maxDelta"` // largest change per operator; 0 means no limit
+	MultiOpPolicy string `json:"multiOpPolicy"`
+
+	CooldownSeconds int `json:"cooldownSeconds"` // per user between updates; 0 disables
+
+	Public bool   `json:"public,omitempty"` // other groups and private chats may query this group
+	Alias  string `json:"alias,omitempty"`  // name for cross-group queries, unique among groups
+
+	FlavorBands []FlavorBand `json:"flavorBands,omitempty"` // empty means defaultFlavorBands
+	Template    string       `json:"template,omitempty"`    // text/template for one counter; empty means defaultReplyTemplate
+}
+
+func defaultGroupConfig() GroupConfig {
+	return GroupConfig{
+		ResetHour:     defaultResetHour,
+		Timezone:      defaultTimezone,
+		MinValue:      defaultMinValue,
+		MaxValue:      defaultMaxValue,
+		MaxDelta:      defaultMaxDelta,
+		MultiOpPolicy: defaultMultiOpPolicy,
+
+		CooldownSeconds: defaultCooldownSeconds,
+	}
+}
+
+func loadGroupConfig(s *database.Store, gid string) GroupConfig {
+	cfg := defaultGroupConfig()
+	raw, found, _ := s.Get(keyPrefixConfig + gid)
+	if !found || raw == "" {
+		return cfg
+	}
+	if json.Unmarshal([]byte(raw), &cfg) != nil {
+		return defaultGroupConfig()
+	}
+	return cfg
+}
+
+func saveGroupConfig(s *database.Store, gid string, cfg GroupConfig) error {
+	raw, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	return s.Set(keyPrefixConfig+gid, string(raw))
+}
+
+// location returns the reset timezone, falling back to UTC.
+func (c GroupConfig) location() *time.Location {
+	loc, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+func (c GroupConfig) skips(d time.Weekday) bool {
+	for _, w := range c.SkipWeekdays {
+		if w == d {
+			return true
+		}
+	}
+	return false
+}
+
+// weekdayNames maps user-facing 1-7 (Monday-Sunday) to time.Weekday.
+var weekdayNames = map[string]time.Weekday{
+	"1": time.Monday, "2": time.Tuesday, "3": time.Wednesday, "4": time.Thursday,
+	"5": time.Friday, "6": time.Saturday, "7": time.Sunday,
+}
+
+func weekdayLabel(d time.Weekday) string {
+	return [...]string{"周日", "周一", "周二", "周三", "周四", "周五", "周六"}[d]
+}
+
+// handleConfigCommands handles the per-group schedule, validation and cooldown commands, and orderCardConfig.
+func handleConfigCommands(ctx protocol.Context) {
+	parts := strings.Fields(strings.TrimSpace(ctx.PlainText()))
+	if len(parts) == 0 {
+		return
+	}
+	prefix := ctx.CommandPrefix()
+	if !strings.HasPrefix(parts[0], prefix) {
+		return
+	}
+	cmd := strings.TrimPrefix(parts[0], prefix)
+	switch cmd {
+	case cmdSetResetHour, cmdSetTimezone, cmdSetSkipDays, cmdSetBounds, cmdSetMaxDelta, cmdSetMultiOp, cmdSetCooldown,
+		cmdSetPublic, cmdSetAlias, cmdShowConfig:
+	default:
+		return
+	}
+	gid := ctx.GroupID()
+	if gid == "" || gid == "0" || !isGroupAdmin(ctx) {
+		return
+	}
+	s := getStore()
+	if s == nil || !isGroupRegistered(s, gid) {
+		_ = ctx.SendPlainMessage("本群未注册 orderCard")
+		return
+	}
+	defer lockGroup(gid)()
+	cfg := loadGroupConfig(s, gid)
+	arg := ""
+	if len(parts) > 1 {
+		arg = parts[1]
+	}
+	switch cmd {
+	case cmdShowConfig:
+		_ = ctx.SendPlainMessage(formatConfig(cfg))
+		return
+	case cmdSetResetHour:
+		h, err := strconv.Atoi(arg)
+		if err != nil || h < 0 || h > 23 {
+			_ = ctx.SendPlainMessage("用法: " + prefix + cmdSetResetHour + " <0-23>")
+			return
+		}
+		cfg.ResetHour = h
+	case cmdSetTimezone:
+		if arg == "" {
+			_ = ctx.SendPlainMessage("用法: " + prefix + cmdSetTimezone + " <时区>，例如 Asia/Shanghai")
+			return
+		}
+		if _, err := time.LoadLocation(arg); err != nil {
+			_ = ctx.SendPlainMessage("无效的时区: " + arg)
+			return
+		}
+		cfg.Timezone = arg
+	case cmdSetSkipDays:
+		days, ok := parseSkipDays(arg)
+		if !ok {
+			_ = ctx.SendPlainMessage("用法: " + prefix + cmdSetSkipDays + " <1-7，逗号分隔|none>，例如 6,7 表示周六周日不重置")
+			return
+		}
+		cfg.SkipWeekdays = days
+	case cmdSetBounds:
+		// {prefix}setOrderCardBounds min max (max 0 = no upper bound)
+		if len(parts) < 3 {
+			_ = ctx.SendPlainMessage("用法: " + prefix + cmdSetBounds + " <最小值> <最大值>，最大值 0 表示不限")
+			return
+		}
+		lo, err1 := strconv.Atoi(parts[1])
+		hi, err2 := strconv.Atoi(parts[2])
+		if err1 != nil || err2 != nil || lo < 0 || hi < 0 || (hi > 0 && hi < lo) {
+			_ = ctx.SendPlainMessage("请提供非负整数，且最大值不小于最小值")
+			return
+		}
+		cfg.MinValue, cfg.MaxValue = lo, hi
+	case cmdSetMaxDelta:
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 0 {
+			_ = ctx.SendPlainMessage("用法: " + prefix + cmdSetMaxDelta + " <n>，0 表示不限")
+			return
+		}
+		cfg.MaxDelta = n
+	case cmdSetMultiOp:
+		if !isValidMultiOpPolicy(arg) {
+			_ = ctx.SendPlainMessage("用法: " + prefix + cmdSetMultiOp + " <all|first|last|reject>\nall: 依次应用全部操作\nfirst: 只应用第一个\nlast: 只应用最后一个\nreject: 拒绝整条消息")
+			return
+		}
+		cfg.MultiOpPolicy = arg
+	case cmdSetCooldown:
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 0 || n > maxCooldownSeconds {
+			_ = ctx.SendPlainMessage("用法: " + prefix + cmdSetCooldown + " <0-" + strconv.Itoa(maxCooldownSeconds) + ">（秒），0 表示不限制")
+			return
+		}
+		cfg.CooldownSeconds = n
+	case cmdSetPublic:
+		switch arg {
+		case "on":
+			cfg.Public = true
+		case "off":
+			cfg.Public = false
+		default:
+			_ = ctx.SendPlainMessage("用法: " + prefix + cmdSetPublic + " <on|off>，开启后其他群和私聊可查询本群人数")
+			return
+		}
+	case cmdSetAlias:
+		if arg == "" {
+			_ = ctx.SendPlainMessage("用法: " + prefix + cmdSetAlias + " <别名|none>")
+			return
+		}
+		if arg == "none" || arg == "无" {
+			cfg.Alias = ""
+			break
+		}
+		if owner, ok := groupByAlias(s, arg); ok && owner != gid {
+			_ = ctx.SendPlainMessage("别名 " + arg + " 已被其他群使用")
+			return
+		}
+		cfg.Alias = arg
+	}
+	if saveGroupConfig(s, gid, cfg) != nil {
+		_ = ctx.SendPlainMessage("保存设置失败")
+		return
+	}
+	_ = ctx.SendPlainMessage("已保存\n" + formatConfig(cfg))
+}
+
+// parseSkipDays parses "6,7" (Monday=1 ... Sunday=7) or "none".
+func parseSkipDays(arg string) ([]time.Weekday, bool) {
+	if arg == "" {
+		return nil, false
+	}
+	if arg == "none" || arg == "无" {
+		return nil, true
+	}
+	seen := make(map[time.Weekday]bool)
+	var days []time.Weekday
+	for _, f := range strings.Split(arg, ",") {
+		d, ok := weekdayNames[strings.TrimSpace(f)]
+		if !ok {
+			return nil, false
+		}
+		if !seen[d] {
+			seen[d] = true
+			days = append(days, d)
+		}
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i] < days[j] })
+	return days, true
+}
+
+func formatConfig(cfg GroupConfig) string {
+	skip := "无"
+	if len(cfg.SkipWeekdays) > 0 {
+		labels := make([]string, 0, len(cfg.SkipWeekdays))
+		for _, d := range cfg.SkipWeekdays {
+			labels = append(labels, weekdayLabel(d))
+		}
+		skip = strings.Join(labels, "、")
+	}
+	msg := "每日重置：" + strconv.Itoa(cfg.ResetHour) + ":00（" + cfg.Timezone + "）\n"
+	msg += "不重置：" + skip + "\n"
+	maxDelta := "不限"
+	if cfg.MaxDelta > 0 {
+		maxDelta = strconv.Itoa(cfg.MaxDelta)
+	}
+	msg += "人数范围：" + formatBounds(cfg) + "\n"
+	msg += "单次变化上限：" + maxDelta + "\n"
+	msg += "多操作策略：" + cfg.MultiOpPolicy + "\n"
+	msg += "更新冷却：" + strconv.Itoa(cfg.CooldownSeconds) + " 秒（管理员不受限）\n"
+	public := "否"
+	if cfg.Public {
+		public = "是"
+	}
+	msg += "允许跨群查询：" + public
+	if cfg.Alias != "" {
+		msg += "（别名 " + cfg.Alias + "）"
+	}
+	if next, ok := nextScheduledReset(cfg, time.Now()); ok {
+		msg += "\n下次重置：" + next.In(cfg.location()).Format("01-02 15:04")
+	}
+	return msg
+}
+
+// groupByAlias returns the registered group whose config has alias.
+func groupByAlias(s *database.Store, alias string) (string, bool) {
+	for _, gid := range registeredGroups(s) {
+		if loadGroupConfig(s, gid).Alias == alias {
+			return gid, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,61 @@
+package pluginordercard
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Hafuunano/Core-SkillAction/timer"
+)
+
+const (
+	defaultCooldownSeconds = 60
+	maxCooldownSeconds     = 3600
+	// keyPrefixCooldown is where cooldowns used to be persisted; leftover keys are purged when the group index is built.
+	keyPrefixCooldown = "orderCard:cooldown:"
+)
+
+// cooldowns maps "gid:uid" to the end of that user's cooldown. Entries expire on their own, so nothing piles up in the store.
+var cooldowns = timer.NewStore[string, time.Time](maxCooldownSeconds * time.Second)
+
+func cooldownKey(gid, uid string) string {
+	return gid + ":" + uid
+}
+
+// cooldownRemaining returns how long uid must still wait before updating in gid; zero when free.
+func cooldownRemaining(gid, uid string, now time.Time) time.Duration {
+	until := cooldowns.Get(cooldownKey(gid, uid))
+	if until.IsZero() || !now.Before(until) {
+		return 0
+	}
+	return until.Sub(now)
+}
+
+// startCooldown blocks uid from updating in gid for d. d <= 0 is a no-op.
+func startCooldown(gid, uid string, now time.Time, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	cooldowns.Set(cooldownKey(gid, uid), now.Add(d))
+}
+
+// clearCooldowns drops every cooldown of gid (e.g. when the group is removed).
+func clearCooldowns(gid string) {
+	prefix := gid + ":"
+	var keys []string
+	_ = cooldowns.Range(func(k string, _ time.Time) error {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+		return nil
+	})
+	for _, k := range keys {
+		cooldowns.Delete(k)
+	}
+}
+
+// formatCooldown tells the user how many seconds remain, rounding up.
+func formatCooldown(remaining time.Duration) string {
+	secs := int((remaining + time.Second - 1) / time.Second)
+	return "更新太频繁啦，请 " + strconv.Itoa(secs) + " 秒后再试"
+}
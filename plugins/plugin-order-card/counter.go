@@ -0,0 +1,171 @@
+package pluginordercard
+
+import (
+	"strings"
+
+	"github.com/Hafuunano/Core-SkillAction/cache/database"
+	"github.com/Hafuunano/Protocol-ConvertTool/protocol"
+)
+
+// Counter commands: group admin or super admin, in a registered group, operate on the current group.
+const (
+	cmdAddCounter    = "addOrderCardCounter"
+	cmdRenameCounter = "renameOrderCardCounter"
+	cmdRemoveCounter = "removeOrderCardCounter"
+	cmdQuery         = "queryOrderCard"
+)
+
+func isGroupAdmin(ctx protocol.Context) bool {
+	return ctx.IsAdmin() || ctx.IsSuperAdmin()
+}
+
+// handleCounterCommands handles addOrderCardCounter, renameOrderCardCounter and removeOrderCardCounter (the last counter cannot be removed).
+func handleCounterCommands(ctx protocol.Context) {
+	parts := strings.Fields(strings.TrimSpace(ctx.PlainText()))
+	if len(parts) == 0 {
+		return
+	}
+	prefix := ctx.CommandPrefix()
+	cmd := parts[0]
+	if cmd != prefix+cmdAddCounter && cmd != prefix+cmdRenameCounter && cmd != prefix+cmdRemoveCounter {
+		return
+	}
+	gid := ctx.GroupID()
+	if gid == "" || gid == "0" || !isGroupAdmin(ctx) {
+		return
+	}
+	s := getStore()
+	if s == nil || !isGroupRegistered(s, gid) {
+		_ = ctx.SendPlainMessage("本群未注册 orderCard")
+		return
+	}
+	defer lockGroup(gid)()
+	data, found := loadGroupData(s, gid)
+	if !found {
+		return
+	}
+	switch cmd {
+	case prefix + cmdAddCounter:
+		// {prefix}addOrderCardCounter name p1 [p2 ...]
+		if len(parts) < 3 {
+			_ = ctx.SendPlainMessage("用法: " + prefix + cmdAddCounter + " <名称> <口令1> [口令2 ...]")
+			return
+		}
+		name, passwords := parts[1], parts[2:]
+		if data.counterIndex(name) >= 0 {
+			_ = ctx.SendPlainMessage("计数器 " + name + " 已存在")
+			return
+		}
+		if msg := checkPasswords(data, -1, passwords); msg != "" {
+			_ = ctx.SendPlainMessage(msg)
+			return
+		}
+		data.Counters = append(data.Counters, Counter{Name: name, Passwords: passwords})
+		if saveGroupData(s, gid, data) != nil {
+			_ = ctx.SendPlainMessage("添加计数器失败")
+			return
+		}
+		_ = ctx.SendPlainMessage("已添加计数器 " + name)
+	case prefix + cmdRenameCounter:
+		if len(parts) < 3 {
+			_ = ctx.SendPlainMessage("用法: " + prefix + cmdRenameCounter + " <原名称> <新名称>")
+			return
+		}
+		oldName, newName := parts[1], parts[2]
+		i := data.counterIndex(oldName)
+		if i < 0 {
+			_ = ctx.SendPlainMessage("没有名为 " + oldName + " 的计数器")
+			return
+		}
+		if data.counterIndex(newName) >= 0 {
+			_ = ctx.SendPlainMessage("计数器 " + newName + " 已存在")
+			return
+		}
+		data.Counters[i].Name = newName
+		if saveGroupData(s, gid, data) != nil {
+			_ = ctx.SendPlainMessage("重命名计数器失败")
+			return
+		}
+		_ = ctx.SendPlainMessage("已将计数器 " + oldName + " 重命名为 " + newName)
+	case prefix + cmdRemoveCounter:
+		if len(parts) < 2 {
+			_ = ctx.SendPlainMessage("用法: " + prefix + cmdRemoveCounter + " <名称>")
+			return
+		}
+		name := parts[1]
+		i := data.counterIndex(name)
+		if i < 0 {
+			_ = ctx.SendPlainMessage("没有名为 " + name + " 的计数器")
+			return
+		}
+		// A registered group always keeps one counter; dropping the group is what unregisterOrderCard is for.
+		if len(data.Counters) == 1 {
+			_ = ctx.SendPlainMessage("不能删除最后一个计数器，如需取消本群注册请用 " + prefix + cmdUnregister)
+			return
+		}
+		data.Counters = append(data.Counters[:i], data.Counters[i+1:]...)
+		if saveGroupData(s, gid, data) != nil {
+			_ = ctx.SendPlainMessage("删除计数器失败")
+			return
+		}
+		_ = ctx.SendPlainMessage("已删除计数器 " + name)
+	}
+}
+
+// handleQuery handles queryOrderCard [gid|alias]: list all counters of the current group in one reply, no password needed.
+// With an argument it queries another group, from any group or private chat, if that group is public (super admins may query any group).
+func handleQuery(ctx protocol.Context) {
+	parts := strings.Fields(strings.TrimSpace(ctx.PlainText()))
+	if len(parts) == 0 || parts[0] != ctx.CommandPrefix()+cmdQuery {
+		return
+	}
+	gid := ctx.GroupID()
+	if gid == "0" {
+		gid = ""
+	}
+	s := getStore()
+	if s == nil {
+		return
+	}
+	if len(parts) == 1 {
+		if gid == "" || !isGroupRegistered(s, gid) {
+			return
+		}
+		defer lockGroup(gid)()
+		data, found := loadGroupData(s, gid)
+		if !found {
+			return
+		}
+		_ = ctx.SendPlainMessage(formatGroup(loadGroupConfig(s, gid), data))
+		return
+	}
+	target, cfg, ok := resolveQueryTarget(s, parts[1])
+	if !ok || (target != gid && !cfg.Public && !ctx.IsSuperAdmin()) {
+		// Same reply for unknown and private groups, so group IDs cannot be probed.
+		_ = ctx.SendPlainMessage("没有找到可查询的群组 " + parts[1])
+		return
+	}
+	defer lockGroup(target)()
+	data, found := loadGroupData(s, target)
+	if !found {
+		_ = ctx.SendPlainMessage("没有找到可查询的群组 " + parts[1])
+		return
+	}
+	name := cfg.Alias
+	if name == "" {
+		name = "群 " + target
+	}
+	_ = ctx.SendPlainMessage("〔" + name + "〕\n" + formatGroup(cfg, data))
+}
+
+// resolveQueryTarget maps a group ID or alias to a registered group and its config.
+func resolveQueryTarget(s *database.Store, arg string) (string, GroupConfig, bool) {
+	gid := arg
+	if !isGroupRegistered(s, gid) {
+		var ok bool
+		if gid, ok = groupByAlias(s, arg); !ok {
+			return "", GroupConfig{}, false
+		}
+	}
+	return gid, loadGroupConfig(s, gid), true
+}
@@ -0,0 +1,279 @@
+package pluginordercard
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Hafuunano/Core-SkillAction/cache/database"
+	"github.com/Hafuunano/Protocol-ConvertTool/protocol"
+)
+
+const (
+	keyPrefixHistory   = "orderCard:history:"
+	keyPrefixArchive   = "orderCard:archive:"
+	maxHistoryEntries  = 200 // per group, oldest dropped first
+	maxArchiveDays     = 30  // per group, oldest dropped first
+	defaultHistoryShow = 10
+	maxHistoryShow     = 50
+	statsDays          = 7
+	cmdHistory         = "orderCardHistory"
+	cmdStats           = "orderCardStats"
+)
+
+// HistoryEntry is one applied headcount change, stored in the JSON array at orderCard:history:{gid}.
+type HistoryEntry struct {
+	Time     string `json:"time"` // RFC3339
+	UserID   string `json:"userId"`
+	Nickname string `json:"nickname"`
+	Counter  string `json:"counter"`
+	Op       string `json:"op"`    // operators as sent, e.g. "+2" or "+2 -1"
+	Value    int    `json:"value"` // counter value after the change (unchanged when rejected)
+
+	Suspicious bool   `json:"suspicious,omitempty"` // failed validation; applied only because an admin sent it
+	Rejected   bool   `json:"rejected,omitempty"`   // failed validation and was not applied
+	Note       string `json:"note,omitempty"`       // validation reasons
+}
+
+// CounterDay summarizes one counter over one day.
+type CounterDay struct {
+	Name  string `json:"name"`
+	Peak  int    `json:"peak"`
+	Final int    `json:"final"`
+	Ops   int    `json:"ops"`
+
+	// HourValues is the highest value seen in each clock hour (group's timezone), carried over hours without changes.
+	// Nil in archives written before predictions existed.
+	HourValues []int `json:"hourValues,omitempty"`
+}
+
+// DayArchive is one archived day of a group, stored in the JSON array at orderCard:archive:{gid} by the daily reset.
+type DayArchive struct {
+	Date     string       `json:"date"` // 2006-01-02 in the group's timezone, the day the period started
+	Counters []CounterDay `json:"counters"`
+	HourOps  [24]int      `json:"hourOps"` // changes per hour of day (group's timezone)
+}
+
+func loadHistory(s *database.Store, gid string) []HistoryEntry {
+	raw, found, _ := s.Get(keyPrefixHistory + gid)
+	if !found || raw == "" {
+		return nil
+	}
+	var entries []HistoryEntry
+	if json.Unmarshal([]byte(raw), &entries) != nil {
+		return nil
+	}
+	return entries
+}
+
+// appendHistory appends e to the group's history, keeping at most maxHistoryEntries.
+func appendHistory(s *database.Store, gid string, e HistoryEntry) {
+	entries := append(loadHistory(s, gid), e)
+	if len(entries) > maxHistoryEntries {
+		entries = entries[len(entries)-maxHistoryEntries:]
+	}
+	raw, err := json.Marshal(entries)
+	if err != nil {
+		return
+	}
+	_ = s.Set(keyPrefixHistory+gid, string(raw))
+}
+
+func loadArchive(s *database.Store, gid string) []DayArchive {
+	raw, found, _ := s.Get(keyPrefixArchive + gid)
+	if !found || raw == "" {
+		return nil
+	}
+	var days []DayArchive
+	if json.Unmarshal([]byte(raw), &days) != nil {
+		return nil
+	}
+	return days
+}
+
+// entriesSince returns the applied entries with Time at or after since; rejected attempts are left out.
+func entriesSince(entries []HistoryEntry, since time.Time) []HistoryEntry {
+	var out []HistoryEntry
+	for _, e := range entries {
+		if e.Rejected {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, e.Time)
+		if err != nil || t.Before(since) {
+			continue
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+// summarizeDay builds the archive of the period [start, now) from the group's history and current counter values.
+func summarizeDay(data GroupData, entries []HistoryEntry, start time.Time, loc *time.Location) DayArchive {
+	day := DayArchive{Date: start.In(loc).Format(time.DateOnly)}
+	entries = entriesSince(entries, start)
+	for _, c := range data.Counters {
+		cd := CounterDay{Name: c.Name, Peak: c.Value, Final: c.Value}
+		for _, e := range entries {
+			if e.Counter != c.Name {
+				continue
+			}
+			cd.Ops++
+			cd.Peak = max(cd.Peak, e.Value)
+		}
+		cd.HourValues = hourValues(c.Name, entries, start, loc)
+		day.Counters = append(day.Counters, cd)
+	}
+	for _, e := range entries {
+		if t, err := time.Parse(time.RFC3339, e.Time); err == nil {
+			day.HourOps[t.In(loc).Hour()]++
+		}
+	}
+	return day
+}
+
+// hourValues walks the 24 clock hours from start's hour, recording the highest value of counter name in each
+// and carrying the last value into hours without changes. The period starts from zero after a reset.
+func hourValues(name string, entries []HistoryEntry, start time.Time, loc *time.Location) []int {
+	var peak, last [24]int
+	var seen [24]bool
+	for _, e := range entries {
+		if e.Counter != name {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, e.Time)
+		if err != nil {
+			continue
+		}
+		h := t.In(loc).Hour()
+		if !seen[h] || e.Value > peak[h] {
+			peak[h] = e.Value
+		}
+		last[h], seen[h] = e.Value, true
+	}
+	values := make([]int, 24)
+	cur := 0
+	for k := range 24 {
+		h := (start.In(loc).Hour() + k) % 24
+		if seen[h] {
+			values[h] = max(peak[h], cur)
+			cur = last[h]
+			continue
+		}
+		values[h] = cur
+	}
+	return values
+}
+
+// archiveDay appends the summary of the period starting at start, keeping at most maxArchiveDays.
+func archiveDay(s *database.Store, gid string, data GroupData, start time.Time, loc *time.Location) {
+	days := append(loadArchive(s, gid), summarizeDay(data, loadHistory(s, gid), start, loc))
+	if len(days) > maxArchiveDays {
+		days = days[len(days)-maxArchiveDays:]
+	}
+	raw, err := json.Marshal(days)
+	if err != nil {
+		return
+	}
+	_ = s.Set(keyPrefixArchive+gid, string(raw))
+}
+
+// handleHistoryCommands handles orderCardHistory [n] and orderCardStats in registered groups.
+func handleHistoryCommands(ctx protocol.Context) {
+	parts := strings.Fields(strings.TrimSpace(ctx.PlainText()))
+	if len(parts) == 0 {
+		return
+	}
+	prefix := ctx.CommandPrefix()
+	if parts[0] != prefix+cmdHistory && parts[0] != prefix+cmdStats {
+		return
+	}
+	gid := ctx.GroupID()
+	if gid == "" || gid == "0" {
+		return
+	}
+	s := getStore()
+	if s == nil || !isGroupRegistered(s, gid) {
+		return
+	}
+	defer lockGroup(gid)()
+	cfg := loadGroupConfig(s, gid)
+	loc := cfg.location()
+	if parts[0] == prefix+cmdHistory {
+		n := defaultHistoryShow
+		if len(parts) > 1 {
+			v, err := strconv.Atoi(parts[1])
+			if err != nil || v <= 0 {
+				_ = ctx.SendPlainMessage("用法: " + prefix + cmdHistory + " [条数]")
+				return
+			}
+			n = min(v, maxHistoryShow)
+		}
+		_ = ctx.SendPlainMessage(formatHistory(loadHistory(s, gid), n, loc))
+		return
+	}
+	data, found := loadGroupData(s, gid)
+	if !found {
+		return
+	}
+	_ = ctx.SendPlainMessage(formatStats(data, loadHistory(s, gid), loadArchive(s, gid), dayStart(cfg, time.Now()), loc))
+}
+
+// formatHistory renders the last n entries, newest last.
+func formatHistory(entries []HistoryEntry, n int, loc *time.Location) string {
+	if len(entries) == 0 {
+		return "暂无更新记录"
+	}
+	if len(entries) > n {
+		entries = entries[len(entries)-n:]
+	}
+	lines := make([]string, 0, len(entries)+1)
+	lines = append(lines, "最近 "+strconv.Itoa(len(entries))+" 次更新：")
+	for _, e := range entries {
+		ts := e.Time
+		if t, err := time.Parse(time.RFC3339, e.Time); err == nil {
+			ts = t.In(loc).Format("01-02 15:04")
+		}
+		line := ts + " " + e.Nickname + " 【" + e.Counter + "】" + e.Op + " → " + strconv.Itoa(e.Value)
+		switch {
+		case e.Rejected:
+			line = "[已拒绝] " + line
+		case e.Suspicious:
+			line = "[可疑] " + line
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// formatStats renders today's peak per counter and the busiest hour over the last statsDays days (archives plus today).
+func formatStats(data GroupData, entries []HistoryEntry, days []DayArchive, todayStart time.Time, loc *time.Location) string {
+	today := summarizeDay(data, entries, todayStart, loc)
+	lines := []string{"今日峰值："}
+	for _, cd := range today.Counters {
+		lines = append(lines, "【"+cd.Name+"】"+strconv.Itoa(cd.Peak)+" 人（"+strconv.Itoa(cd.Ops)+" 次更新）")
+	}
+	hourOps := today.HourOps
+	cutoff := todayStart.In(loc).AddDate(0, 0, -(statsDays - 1)).Format(time.DateOnly)
+	for _, d := range days {
+		if d.Date < cutoff {
+			continue
+		}
+		for h, n := range d.HourOps {
+			hourOps[h] += n
+		}
+	}
+	busiest, total := 0, 0
+	for h, n := range hourOps {
+		total += n
+		if n > hourOps[busiest] {
+			busiest = h
+		}
+	}
+	if total == 0 {
+		lines = append(lines, "近 "+strconv.Itoa(statsDays)+" 天暂无更新记录")
+	} else {
+		lines = append(lines, "近 "+strconv.Itoa(statsDays)+" 天最忙时段："+strconv.Itoa(busiest)+":00-"+strconv.Itoa(busiest+1)+":00（"+strconv.Itoa(hourOps[busiest])+" 次更新）")
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,98 @@
+package pluginordercard
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/Hafuunano/Core-SkillAction/cache/database"
+)
+
+// keyIndexGroups holds the JSON array of registered group IDs, so orderCard never has to walk the shared store
+// (s.List returns every plugin's keys) to find its own groups.
+const keyIndexGroups = "orderCard:index:groups"
+
+// indexMu serializes read-modify-write of the index and its one-time migration.
+var indexMu sync.Mutex
+
+// registeredGroups returns the registered group IDs in ascending order.
+// The first call on a store written by an older version builds the index with a single full scan.
+func registeredGroups(s *database.Store) []string {
+	indexMu.Lock()
+	defer indexMu.Unlock()
+	gids, found := loadGroupIndex(s)
+	if !found {
+		gids = migrateGroupIndex(s)
+	}
+	return gids
+}
+
+func loadGroupIndex(s *database.Store) ([]string, bool) {
+	raw, found, _ := s.Get(keyIndexGroups)
+	if !found {
+		return nil, false
+	}
+	var gids []string
+	if raw != "" && json.Unmarshal([]byte(raw), &gids) != nil {
+		return nil, false
+	}
+	return gids, true
+}
+
+func saveGroupIndex(s *database.Store, gids []string) error {
+	if gids == nil {
+		gids = []string{}
+	}
+	raw, err := json.Marshal(gids)
+	if err != nil {
+		return err
+	}
+	return s.Set(keyIndexGroups, string(raw))
+}
+
+// migrateGroupIndex builds the index from orderCard:group:* keys and drops legacy orderCard:cooldown:* keys on the way.
+// Caller holds indexMu.
+func migrateGroupIndex(s *database.Store) []string {
+	var gids []string
+	for _, e := range s.List() {
+		if gid, ok := strings.CutPrefix(e.Key, keyPrefixGroup); ok && gid != "" {
+			gids = append(gids, gid)
+		} else if strings.HasPrefix(e.Key, keyPrefixCooldown) {
+			_ = s.Delete(e.Key)
+		}
+	}
+	slices.Sort(gids)
+	gids = slices.Compact(gids)
+	_ = saveGroupIndex(s, gids)
+	return gids
+}
+
+// indexGroup adds gid to the index; unindexGroup removes it. Both are no-ops when already in that state.
+func indexGroup(s *database.Store, gid string) error {
+	indexMu.Lock()
+	defer indexMu.Unlock()
+	gids, found := loadGroupIndex(s)
+	if !found {
+		gids = migrateGroupIndex(s)
+	}
+	i, ok := slices.BinarySearch(gids, gid)
+	if ok {
+		return nil
+	}
+	return saveGroupIndex(s, slices.Insert(gids, i, gid))
+}
+
+func unindexGroup(s *database.Store, gid string) error {
+	indexMu.Lock()
+	defer indexMu.Unlock()
+	gids, found := loadGroupIndex(s)
+	if !found {
+		gids = migrateGroupIndex(s)
+	}
+	i, ok := slices.BinarySearch(gids, gid)
+	if !ok {
+		return nil
+	}
+	return saveGroupIndex(s, slices.Delete(gids, i, i+1))
+}
@@ -0,0 +1,91 @@
+package pluginordercard
+
+import (
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Hafuunano/Core-SkillAction/cache/database"
+	coredb "github.com/Hafuunano/Core-SkillAction/database"
+)
+
+// newTestStore opens a fresh store in a temp dir, pre-filled with unrelated keys of other plugins.
+func newTestStore(tb testing.TB, unrelated int) *database.Store {
+	tb.Helper()
+	db, err := coredb.OpenDB(filepath.Join(tb.TempDir(), "orderCard.db"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	db.DB().Logger = db.DB().Logger.LogMode(1) // gorm logger.Silent: misses on Get are expected
+	if err := db.Migrate(&coredb.Entry{}); err != nil {
+		tb.Fatal(err)
+	}
+	if unrelated > 0 {
+		rows := make([]coredb.Entry, unrelated)
+		for i := range rows {
+			rows[i] = coredb.Entry{Key: "otherPlugin:key:" + strconv.Itoa(i), Value: "x"}
+		}
+		if err := db.DB().CreateInBatches(rows, 500).Error; err != nil {
+			tb.Fatal(err)
+		}
+	}
+	s := database.New(db.DB())
+	if err := s.LoadInMemory(); err != nil {
+		tb.Fatal(err)
+	}
+	return s
+}
+
+// benchmarkGroups is how many orderCard groups sit among the unrelated keys.
+const benchmarkGroups = 10
+
+// BenchmarkRunResets compares finding the groups through the index with the full store scan it replaced
+// ("scan" drops the index before every run, so registeredGroups walks all 100k keys again).
+func BenchmarkRunResets(b *testing.B) {
+	s := newTestStore(b, 100_000)
+	for i := 0; i < benchmarkGroups; i++ {
+		if err := registerGroup(s, strconv.Itoa(1000+i), []string{"pw" + strconv.Itoa(i)}); err != nil {
+			b.Fatal(err)
+		}
+	}
+	now := time.Now()
+	for _, mode := range []string{"index", "scan"} {
+		b.Run(mode, func(b *testing.B) {
+			for b.Loop() {
+				if mode == "scan" {
+					b.StopTimer()
+					_ = s.Delete(keyIndexGroups)
+					b.StartTimer()
+				}
+				runResets(s, now)
+			}
+		})
+	}
+}
+
+// BenchmarkRemoveGroup times removeGroup with the index in place and with the full scan it falls back to without one.
+func BenchmarkRemoveGroup(b *testing.B) {
+	s := newTestStore(b, 100_000)
+	for i := 0; i < benchmarkGroups; i++ {
+		if err := registerGroup(s, strconv.Itoa(1000+i), []string{"pw" + strconv.Itoa(i)}); err != nil {
+			b.Fatal(err)
+		}
+	}
+	const gid = "999"
+	for _, mode := range []string{"index", "scan"} {
+		b.Run(mode, func(b *testing.B) {
+			for b.Loop() {
+				b.StopTimer()
+				if err := registerGroup(s, gid, []string{"bench"}); err != nil {
+					b.Fatal(err)
+				}
+				if mode == "scan" {
+					_ = s.Delete(keyIndexGroups)
+				}
+				b.StartTimer()
+				removeGroup(s, gid)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package pluginordercard
+
+import "sync"
+
+// groupLock is one group's mutex plus the number of goroutines holding or waiting on it.
+type groupLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+var (
+	groupLocksMu sync.Mutex
+	groupLocks   = make(map[string]*groupLock)
+)
+
+// lockGroup serializes read-modify-write of one group's keys (data, config, history, archive) within the process.
+// The store has no compare-and-swap, so every writer of a group's keys must hold this. Call the returned func to unlock.
+// Entries are dropped once nobody holds or waits on them, so the map only grows with concurrently active groups.
+func lockGroup(gid string) (unlock func()) {
+	groupLocksMu.Lock()
+	l := groupLocks[gid]
+	if l == nil {
+		l = &groupLock{}
+		groupLocks[gid] = l
+	}
+	l.refs++
+	groupLocksMu.Unlock()
+
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+		groupLocksMu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(groupLocks, gid)
+		}
+		groupLocksMu.Unlock()
+	}
+}
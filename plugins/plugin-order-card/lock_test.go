@@ -0,0 +1,95 @@
+package pluginordercard
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestProcessMessageConcurrentIncrements fires many +1 at one group at once; with the group lock none may be lost.
+func TestProcessMessageConcurrentIncrements(t *testing.T) {
+	s := newTestStore(t, 0)
+	const gid, ops = "20001", 300
+	if err := registerGroup(s, gid, []string{"pw"}); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < ops; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			reply := processMessage(s, gid, messageUpdate{
+				Text:     "pw +1",
+				UserID:   strconv.Itoa(i),
+				Nickname: "user" + strconv.Itoa(i),
+				Admin:    true, // no cooldown, and the default bounds do not reject the growing value
+				Now:      time.Now(),
+			})
+			if reply == "" {
+				t.Errorf("op %d: no reply", i)
+			}
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+	data, found := loadGroupData(s, gid)
+	if !found || len(data.Counters) != 1 {
+		t.Fatalf("group data = %+v, found %v", data, found)
+	}
+	if got := data.Counters[0].Value; got != ops {
+		t.Fatalf("counter = %d after %d concurrent +1, want %d", got, ops, ops)
+	}
+	groupLocksMu.Lock()
+	_, left := groupLocks[gid]
+	groupLocksMu.Unlock()
+	if left {
+		t.Error("group lock entry left after all writers finished")
+	}
+}
+
+// TestProcessMessageConcurrentCooldown fires many +1 from one non-admin user at once: the cooldown check and its
+// start share the group lock, so exactly one update lands and every other message gets the cooldown reply.
+func TestProcessMessageConcurrentCooldown(t *testing.T) {
+	s := newTestStore(t, 0)
+	const gid, ops = "20002", 100
+	if err := registerGroup(s, gid, []string{"pw"}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { clearCooldowns(gid) })
+	now := time.Now()
+	replies := make([]string, ops)
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < ops; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			replies[i] = processMessage(s, gid, messageUpdate{Text: "pw +1", UserID: "10001", Nickname: "user", Now: now})
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+	applied, cooled := 0, 0
+	for i, r := range replies {
+		switch {
+		case strings.HasPrefix(r, "更新太频繁啦"):
+			cooled++
+		case r != "":
+			applied++
+		default:
+			t.Errorf("op %d: no reply", i)
+		}
+	}
+	if applied != 1 || cooled != ops-1 {
+		t.Fatalf("%d applied and %d cooldown replies, want 1 and %d", applied, cooled, ops-1)
+	}
+	data, _ := loadGroupData(s, gid)
+	if got := data.Counters[0].Value; got != 1 {
+		t.Fatalf("counter = %d, want 1", got)
+	}
+}
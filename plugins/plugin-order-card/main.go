@@ -1,9 +1,13 @@
-// Package pluginordercard: orderCard plugin. Register groups (one at a time, 1 or 2 passwords per group),
-// respond when message matches group password with +n/-n/=n and 1-min cooldown per user; daily 4am reset value only.
+// Package pluginordercard: orderCard plugin. Register groups (super admin by group ID, or group admins in their own group),
+// each with one or more named counters (up to 10 passwords per counter, managed in-group); respond when message matches a counter password with +n/-n/=n (validated against per-group
+// bounds, see validate.go) and a per-group cooldown per user (default 1 min, admins exempt);
+// every change is kept in a bounded history; at each group's reset time (default 4am, per-group hour, timezone and
+// skipped weekdays) the day is archived and values reset. Public groups can be queried from other groups and private chat.
+// Replies are rendered from a per-group text/template with configurable flavor bands (see reply.go); counters with a
+// machine setup also show the estimated wait, and archived hourly values drive per-hour predictions (see wait.go).
 package pluginordercard
 
 import (
-	"encoding/json"
 	"regexp"
 	"strconv"
 	"strings"
@@ -17,12 +21,8 @@ import (
 )
 
 const (
-	keyPrefixGroup    = "orderCard:group:"
-	keyPrefixData     = "orderCard:data:"
-	keyPrefixCooldown = "orderCard:cooldown:"
-	cooldownDuration  = time.Minute
-	resetHour         = 4
-	timezone          = "Asia/Shanghai"
+	keyPrefixGroup = "orderCard:group:"
+	keyPrefixData  = "orderCard:data:"
 )
 
 // formatUpdatedAt returns relative time like "5分钟前" or "2小时前" from RFC3339 UpdatedAt.
@@ -48,24 +48,18 @@ func formatUpdatedAt(updatedAt string) string {
 	return strconv.Itoa(days) + "天前"
 }
 
-// flavorByValue returns a short line based on current headcount (0-4 / 5-10 / >10).
-func flavorByValue(n int) string {
-	switch {
-	case n <= 4:
-		return "看起来今天还没有人出勤诶xwx"
-	case n <= 10:
-		return "人有点多，稍微考虑一下再去吧！"
-	default:
-		return "呜呜，太多了，不去了"
+// flavorByValue returns the text of the band with the highest Min not above n (defaults: 0-4 / 5-10 / >10).
+func flavorByValue(bands []FlavorBand, n int) string {
+	if len(bands) == 0 {
+		bands = defaultFlavorBands
 	}
-}
-
-// GroupData is the JSON stored at orderCard:data:{gid}.
-type GroupData struct {
-	Value           int      `json:"value"`
-	UpdatedAt       string   `json:"updatedAt"`
-	LastUpdaterName string   `json:"lastUpdaterName"`
-	Passwords       []string `json:"passwords"`
+	text, best := "", -1
+	for _, b := range bands {
+		if b.Min <= n && b.Min > best {
+			text, best = b.Text, b.Min
+		}
+	}
+	return text
 }
 
 var (
@@ -86,14 +80,30 @@ func SetStore(s *database.Store) {
 }
 
 func init() {
-	// Super admin only: register one group with 1 or 2 passwords.
+	// Super admin only: register one group, or replace the passwords of its first counter.
 	p.OnMessage().IsOnlySuperAdmin().Func(handleSetOrderCardRegister)
 	// Super admin only: remove one group.
 	p.OnMessage().IsOnlySuperAdmin().Func(handleRemoveOrderCardRegister)
+	// Super admin only: export every group as JSON, or import such a document.
+	p.OnMessage().IsOnlySuperAdmin().Func(handleTransferCommands)
+	// Group admin or super admin: register/unregister the current group and manage its passwords.
+	p.OnMessage().Func(handlePasswordCommands)
+	// Group admin or super admin: add, rename, remove counters of the current group.
+	p.OnMessage().Func(handleCounterCommands)
+	// List all counters of the current group, or of a public group from anywhere.
+	p.OnMessage().Func(handleQuery)
+	// Recent changes and daily statistics of the current group.
+	p.OnMessage().Func(handleHistoryCommands)
 	// All messages in registered groups: hit password then +n/-n/=n.
 	p.OnMessage().Func(handleOrderCardMessage)
-	// Daily 4am reset value only (keep updatedAt, lastUpdaterName, passwords).
-	go runDailyReset()
+	// Group admin or super admin: per-group reset hour, timezone and skipped weekdays.
+	p.OnMessage().Func(handleConfigCommands)
+	// Group admin or super admin: flavor text bands and the reply template.
+	p.OnMessage().Func(handleReplyCommands)
+	// Machine setup per counter, estimated wait and per-hour predictions from the archives.
+	p.OnMessage().Func(handleWaitCommands)
+	// Archive the day and reset counter values at each group's scheduled time (default 4am Asia/Shanghai).
+	StartResetScheduler(nil)
 }
 
 func getStore() *database.Store {
@@ -124,35 +134,69 @@ func handleSetOrderCardRegister(ctx protocol.Context) {
 		return
 	}
 	parts := strings.Fields(text)
-	// {prefix}setOrderCardRegister gid [p1] [p2]
-	if len(parts) < 2 {
-		_ = ctx.SendPlainMessage("用法: " + prefix + "setOrderCardRegister <群号> <口令1> [口令2]")
+	// {prefix}setOrderCardRegister gid p1 [p2 ...]
+	if len(parts) < 3 {
+		_ = ctx.SendPlainMessage("用法: " + prefix + "setOrderCardRegister <群号> <口令1> [口令2 ...]")
 		return
 	}
-	gid := parts[1]
-	if gid == "" {
+	gid, passwords := parts[1], parts[2:]
+	s := getStore()
+	if s == nil {
+		_ = ctx.SendPlainMessage("orderCard 未初始化 store")
 		return
 	}
-	passwords := parts[2:]
-	if len(passwords) == 0 || len(passwords) > 2 {
-		_ = ctx.SendPlainMessage("请提供 1 或 2 个口令")
+	defer lockGroup(gid)()
+	// Already registered: only swap the passwords, keeping the counter value.
+	if data, found := loadGroupData(s, gid); isGroupRegistered(s, gid) && found && len(data.Counters) > 0 {
+		if msg := checkPasswords(data, 0, passwords); msg != "" {
+			_ = ctx.SendPlainMessage(msg)
+			return
+		}
+		data.Counters[0].Passwords = passwords
+		if saveGroupData(s, gid, data) != nil {
+			_ = ctx.SendPlainMessage("更新口令失败")
+			return
+		}
+		_ = ctx.SendPlainMessage("群组 " + gid + " 已注册，已更新计数器 " + data.Counters[0].Name + " 的口令")
 		return
 	}
-	s := getStore()
-	if s == nil {
-		_ = ctx.SendPlainMessage("orderCard 未初始化 store")
+	if msg := checkPasswords(GroupData{}, -1, passwords); msg != "" {
+		_ = ctx.SendPlainMessage(msg)
 		return
 	}
-	if err := s.Set(keyPrefixGroup+gid, "1"); err != nil {
+	if registerGroup(s, gid, passwords) != nil {
 		_ = ctx.SendPlainMessage("注册群组失败")
 		return
 	}
-	data := GroupData{Value: 0, Passwords: passwords}
-	raw, _ := json.Marshal(data)
-	_ = s.Set(keyPrefixData+gid, string(raw))
 	_ = ctx.SendPlainMessage("已注册群组 " + gid + "，口令已设置")
 }
 
+// registerGroup marks gid as registered with a single default counter guarded by passwords.
+func registerGroup(s *database.Store, gid string, passwords []string) error {
+	if err := s.Set(keyPrefixGroup+gid, "1"); err != nil {
+		return err
+	}
+	if err := indexGroup(s, gid); err != nil {
+		return err
+	}
+	data := GroupData{
+		Counters:    []Counter{{Name: defaultCounterName, Passwords: passwords}},
+		LastResetAt: time.Now().Format(time.RFC3339),
+	}
+	return saveGroupData(s, gid, data)
+}
+
+// removeGroup deletes everything stored for gid.
+func removeGroup(s *database.Store, gid string) {
+	_ = s.Delete(keyPrefixGroup + gid)
+	_ = s.Delete(keyPrefixData + gid)
+	_ = s.Delete(keyPrefixHistory + gid)
+	_ = s.Delete(keyPrefixArchive + gid)
+	_ = s.Delete(keyPrefixConfig + gid)
+	_ = unindexGroup(s, gid)
+	clearCooldowns(gid)
+}
+
 func handleRemoveOrderCardRegister(ctx protocol.Context) {
 	text := strings.TrimSpace(ctx.PlainText())
 	prefix := ctx.CommandPrefix()
@@ -169,15 +213,9 @@ func handleRemoveOrderCardRegister(ctx protocol.Context) {
 	if s == nil {
 		return
 	}
-	_ = s.Delete(keyPrefixGroup + gid)
-	_ = s.Delete(keyPrefixData + gid)
-	// Remove cooldown keys for this group
-	cooldownKeyPrefix := keyPrefixCooldown + gid + ":"
-	for _, e := range s.List() {
-		if strings.HasPrefix(e.Key, cooldownKeyPrefix) {
-			_ = s.Delete(e.Key)
-		}
-	}
+	unlock := lockGroup(gid)
+	removeGroup(s, gid)
+	unlock()
 	_ = ctx.SendPlainMessage("已删除群组 " + gid)
 }
 
@@ -193,118 +231,86 @@ func handleOrderCardMessage(ctx protocol.Context) {
 	if s == nil || !isGroupRegistered(s, gid) {
 		return
 	}
-	raw, found, _ := s.Get(keyPrefixData + gid)
-	if !found || raw == "" {
-		return
-	}
-	var data GroupData
-	if err := json.Unmarshal([]byte(raw), &data); err != nil {
-		return
-	}
-	plain := strings.TrimSpace(ctx.PlainText())
-	// Trigger when message equals a password, or first word equals password, or starts with password immediately followed by op (e.g. "mypass", "mypass +10", "mypass=10")
-	firstWord := ""
-	if f := strings.Fields(plain); len(f) > 0 {
-		firstWord = strings.TrimSpace(f[0])
+	reply := processMessage(s, gid, messageUpdate{
+		Text:     strings.TrimSpace(ctx.PlainText()),
+		UserID:   ctx.UserID(),
+		Nickname: ctx.SenderNickname(),
+		Admin:    isGroupAdmin(ctx),
+		Now:      time.Now(),
+	})
+	if reply != "" {
+		_ = ctx.SendPlainMessage(reply)
 	}
-	hitPassword := false
-	for _, p := range data.Passwords {
-		pw := strings.TrimSpace(p)
-		if plain == pw || firstWord == pw {
-			hitPassword = true
-			break
-		}
-		// Allow "password=10" (no space between password and operator)
-		if strings.HasPrefix(plain, pw) && len(pw) > 0 {
-			rest := plain[len(pw):]
-			if rest == "" || rest[0] == '+' || rest[0] == '-' || rest[0] == '=' {
-				hitPassword = true
-				break
-			}
-		}
+}
+
+// messageUpdate is what processMessage needs from one group message.
+type messageUpdate struct {
+	Text     string
+	UserID   string
+	Nickname string
+	Admin    bool
+	Now      time.Time
+}
+
+// processMessage matches m against the group's passwords, applies its +n/-n/=n and returns the reply ("" when no password hit).
+// The cooldown check, the update and its history entry run under the group lock, so concurrent messages never lose updates.
+func processMessage(s *database.Store, gid string, m messageUpdate) string {
+	defer lockGroup(gid)()
+	data, found := loadGroupData(s, gid)
+	if !found {
+		return ""
 	}
-	if !hitPassword {
-		return
+	idx := data.matchCounter(m.Text)
+	if idx < 0 {
+		return ""
 	}
-	// Apply +n / -n / =n from same message (one cooldown check for the whole message)
-	ops := opRegex.FindAllStringSubmatch(plain, -1)
-	uid := ctx.UserID()
-	now := time.Now()
-	cooldownKey := keyPrefixCooldown + gid + ":" + uid
+	c := &data.Counters[idx]
+	// Apply +n / -n / =n from same message (one cooldown check for the whole message), validated by applyOps
+	ops := opRegex.FindAllStringSubmatch(m.Text, -1)
+	flagged := ""
+	cfg := loadGroupConfig(s, gid)
 	if len(ops) > 0 {
-		lastStr, found, _ := s.Get(cooldownKey)
-		if found && lastStr != "" {
-			last, _ := time.Parse(time.RFC3339, lastStr)
-			if now.Sub(last) < cooldownDuration {
-				_ = ctx.SendPlainMessage("每人一分钟内只能更新一次")
-				return
+		// Group admins and super admins are not throttled.
+		if !m.Admin {
+			if remaining := cooldownRemaining(gid, m.UserID, m.Now); remaining > 0 {
+				return formatCooldown(remaining)
 			}
 		}
-		for _, m := range ops {
-			if len(m) != 3 {
-				continue
-			}
-			op, numStr := m[1], m[2]
-			n, err := strconv.Atoi(numStr)
-			if err != nil {
-				continue
-			}
-			switch op {
-			case "+":
-				data.Value += n
-			case "-":
-				data.Value -= n
-			case "=":
-				data.Value = n
-			default:
-				continue
-			}
+		res := applyOps(cfg, c.Value, ops, m.Admin)
+		if len(res.Applied) == 0 && res.Rejected == "" {
+			// Nothing to apply: no update, no history entry, no cooldown.
+			return formatCounter(cfg, *c, len(data.Counters) > 1 || c.Name != defaultCounterName)
 		}
-		data.UpdatedAt = now.Format(time.RFC3339)
-		data.LastUpdaterName = ctx.SenderNickname()
-		newRaw, _ := json.Marshal(data)
-		_ = s.Set(keyPrefixData+gid, string(newRaw))
-		_ = s.Set(cooldownKey, now.Format(time.RFC3339))
-	}
-	// Output: 当前人数 / 更新时间 / 更新人 + flavor by range
-	msg := "当前人数：" + strconv.Itoa(data.Value) + "\n"
-	if data.UpdatedAt != "" {
-		msg += "更新时间：" + formatUpdatedAt(data.UpdatedAt) + "\n"
-	}
-	if data.LastUpdaterName != "" {
-		msg += "更新人：" + data.LastUpdaterName + "\n"
-	}
-	msg += flavorByValue(data.Value)
-	_ = ctx.SendPlainMessage(msg)
-}
-
-func runDailyReset() {
-	loc, err := time.LoadLocation(timezone)
-	if err != nil {
-		loc = time.UTC
-	}
-	for {
-		now := time.Now().In(loc)
-		next := time.Date(now.Year(), now.Month(), now.Day(), resetHour, 0, 0, 0, loc)
-		if !now.Before(next) {
-			next = next.AddDate(0, 0, 1)
+		if !m.Admin {
+			startCooldown(gid, m.UserID, m.Now, time.Duration(cfg.CooldownSeconds)*time.Second)
 		}
-		time.Sleep(next.Sub(now))
-		s := getStore()
-		if s == nil {
-			continue
+		entry := HistoryEntry{
+			Time:       m.Now.Format(time.RFC3339),
+			UserID:     m.UserID,
+			Nickname:   m.Nickname,
+			Counter:    c.Name,
+			Op:         strings.Join(res.Applied, " "),
+			Value:      c.Value,
+			Suspicious: len(res.Flags) > 0,
+			Rejected:   res.Rejected != "",
+			Note:       strings.Join(res.Flags, "；"),
 		}
-		for _, e := range s.List() {
-			if !strings.HasPrefix(e.Key, keyPrefixData) {
-				continue
-			}
-			var data GroupData
-			if json.Unmarshal([]byte(e.Value), &data) != nil {
-				continue
+		if res.Rejected != "" {
+			if entry.Note == "" {
+				entry.Note = res.Rejected
 			}
-			data.Value = 0
-			newRaw, _ := json.Marshal(data)
-			_ = s.Set(e.Key, string(newRaw))
+			appendHistory(s, gid, entry)
+			return "更新被拒绝：" + res.Rejected
+		}
+		c.Value = res.Value
+		c.UpdatedAt = entry.Time
+		c.LastUpdaterName = entry.Nickname
+		_ = saveGroupData(s, gid, data)
+		entry.Value = c.Value
+		appendHistory(s, gid, entry)
+		if entry.Suspicious {
+			flagged = "⚠ 该修改已标记为可疑：" + entry.Note + "\n"
 		}
 	}
+	return flagged + formatCounter(cfg, *c, len(data.Counters) > 1 || c.Name != defaultCounterName)
 }
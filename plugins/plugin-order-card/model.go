@@ -0,0 +1,132 @@
+package pluginordercard
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/Hafuunano/Core-SkillAction/cache/database"
+)
+
+// defaultCounterName names the counter created on registration and the one legacy single-value records migrate into.
+const defaultCounterName = "默认"
+
+// Counter is one named headcount within a group (e.g. one arcade), with its own passwords.
+type Counter struct {
+	Name            string   `json:"name"`
+	Value           int      `json:"value"`
+	UpdatedAt       string   `json:"updatedAt"`
+	LastUpdaterName string   `json:"lastUpdaterName"`
+	Passwords       []string `json:"passwords"`
+
+	Machines       int `json:"machines,omitempty"`       // machines players queue for; 0 means no wait estimate
+	AvgPlayMinutes int `json:"avgPlayMinutes,omitempty"` // average minutes one player occupies a machine
+}
+
+// GroupData is the JSON stored at orderCard:data:{gid}.
+type GroupData struct {
+	Counters    []Counter `json:"counters"`
+	LastResetAt string    `json:"lastResetAt,omitempty"` // RFC3339 of the last scheduled reset applied
+}
+
+// storedGroupData reads both layouts of orderCard:data:{gid}: counters, or the legacy single value at top level.
+type storedGroupData struct {
+	Counters        []Counter `json:"counters"`
+	LastResetAt     string    `json:"lastResetAt"`
+	Value           int       `json:"value"`
+	UpdatedAt       string    `json:"updatedAt"`
+	LastUpdaterName string    `json:"lastUpdaterName"`
+	Passwords       []string  `json:"passwords"`
+}
+
+// parseGroupData decodes raw; migrated is true when raw used the legacy single-value layout.
+func parseGroupData(raw string) (data GroupData, migrated bool, err error) {
+	var st storedGroupData
+	if err := json.Unmarshal([]byte(raw), &st); err != nil {
+		return GroupData{}, false, err
+	}
+	if st.Counters != nil {
+		return GroupData{Counters: st.Counters, LastResetAt: st.LastResetAt}, false, nil
+	}
+	legacy := Counter{
+		Name:            defaultCounterName,
+		Value:           st.Value,
+		UpdatedAt:       st.UpdatedAt,
+		LastUpdaterName: st.LastUpdaterName,
+		Passwords:       st.Passwords,
+	}
+	return GroupData{Counters: []Counter{legacy}}, true, nil
+}
+
+// loadGroupData reads orderCard:data:{gid}. Legacy single-value records are migrated and written back.
+func loadGroupData(s *database.Store, gid string) (GroupData, bool) {
+	raw, found, _ := s.Get(keyPrefixData + gid)
+	if !found || raw == "" {
+		return GroupData{}, false
+	}
+	data, migrated, err := parseGroupData(raw)
+	if err != nil {
+		return GroupData{}, false
+	}
+	if migrated {
+		_ = saveGroupData(s, gid, data)
+	}
+	return data, true
+}
+
+func saveGroupData(s *database.Store, gid string, data GroupData) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return s.Set(keyPrefixData+gid, string(raw))
+}
+
+// counterIndex returns the index of the counter named name, or -1.
+func (d *GroupData) counterIndex(name string) int {
+	for i := range d.Counters {
+		if d.Counters[i].Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// passwordOwner returns the index of the counter that has password pw, or -1.
+func (d *GroupData) passwordOwner(pw string) int {
+	for i := range d.Counters {
+		for _, p := range d.Counters[i].Passwords {
+			if strings.TrimSpace(p) == pw {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// matchCounter returns the index of the counter whose password the message hits, or -1.
+// Hit when message equals a password, or first word equals password, or starts with password immediately followed by op (e.g. "mypass", "mypass +10", "mypass=10").
+func (d *GroupData) matchCounter(plain string) int {
+	firstWord := ""
+	if f := strings.Fields(plain); len(f) > 0 {
+		firstWord = strings.TrimSpace(f[0])
+	}
+	for i := range d.Counters {
+		for _, p := range d.Counters[i].Passwords {
+			pw := strings.TrimSpace(p)
+			if pw == "" {
+				continue
+			}
+			if plain == pw || firstWord == pw {
+				return i
+			}
+			// Allow "password=10" (no space between password and operator)
+			if strings.HasPrefix(plain, pw) {
+				rest := plain[len(pw):]
+				if rest == "" || rest[0] == '+' || rest[0] == '-' || rest[0] == '=' {
+					return i
+				}
+			}
+		}
+	}
+	return -1
+}
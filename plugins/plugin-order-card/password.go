@@ -0,0 +1,173 @@
+package pluginordercard
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/Hafuunano/Protocol-ConvertTool/protocol"
+)
+
+// Self-service commands: group admin or super admin, operate on the current group.
+const (
+	cmdRegister       = "registerOrderCard"
+	cmdUnregister     = "unregisterOrderCard"
+	cmdAddPassword    = "addOrderCardPassword"
+	cmdRemovePassword = "removeOrderCardPassword"
+	cmdListPasswords  = "listOrderCardPasswords"
+	// maxPasswordsPerCounter keeps a single counter from swallowing every short message in the group.
+	maxPasswordsPerCounter = 10
+)
+
+// checkPassword returns why pw cannot be added to counter idx of data, or "" when it can.
+// Passwords may not contain operator characters, or "口令+1" would no longer match.
+func checkPassword(data GroupData, idx int, pw string) string {
+	if pw == "" || strings.ContainsAny(pw, "+-=") {
+		return "口令不能为空，也不能包含 + - ="
+	}
+	if i := data.passwordOwner(pw); i >= 0 {
+		return "口令 " + pw + " 已被计数器 " + data.Counters[i].Name + " 使用"
+	}
+	if idx >= 0 && len(data.Counters[idx].Passwords) >= maxPasswordsPerCounter {
+		return "每个计数器最多 " + strconv.Itoa(maxPasswordsPerCounter) + " 个口令"
+	}
+	return ""
+}
+
+// checkPasswords validates a fresh password list for a new counter (or a replacement list for counter skip).
+func checkPasswords(data GroupData, skip int, passwords []string) string {
+	if len(passwords) == 0 || len(passwords) > maxPasswordsPerCounter {
+		return "请提供 1 到 " + strconv.Itoa(maxPasswordsPerCounter) + " 个口令"
+	}
+	for i, pw := range passwords {
+		if slices.Contains(passwords[:i], pw) {
+			return "口令 " + pw + " 重复"
+		}
+		if owner := data.passwordOwner(pw); owner >= 0 && owner != skip {
+			return "口令 " + pw + " 已被计数器 " + data.Counters[owner].Name + " 使用"
+		}
+		if msg := checkPassword(GroupData{}, -1, pw); msg != "" {
+			return msg
+		}
+	}
+	return ""
+}
+
+// handlePasswordCommands handles registerOrderCard, unregisterOrderCard and the password commands for the current group.
+func handlePasswordCommands(ctx protocol.Context) {
+	parts := strings.Fields(strings.TrimSpace(ctx.PlainText()))
+	if len(parts) == 0 {
+		return
+	}
+	prefix := ctx.CommandPrefix()
+	cmd, ok := strings.CutPrefix(parts[0], prefix)
+	if !ok {
+		return
+	}
+	switch cmd {
+	case cmdRegister, cmdUnregister, cmdAddPassword, cmdRemovePassword, cmdListPasswords:
+	default:
+		return
+	}
+	gid := ctx.GroupID()
+	if gid == "" || gid == "0" || !isGroupAdmin(ctx) {
+		return
+	}
+	s := getStore()
+	if s == nil {
+		_ = ctx.SendPlainMessage("orderCard 未初始化 store")
+		return
+	}
+	defer lockGroup(gid)()
+	if cmd == cmdRegister {
+		// {prefix}registerOrderCard p1 [p2 ...]
+		if isGroupRegistered(s, gid) {
+			_ = ctx.SendPlainMessage("本群已注册 orderCard，可用 " + prefix + cmdAddPassword + " 添加口令")
+			return
+		}
+		if len(parts) < 2 {
+			_ = ctx.SendPlainMessage("用法: " + prefix + cmdRegister + " <口令1> [口令2 ...]")
+			return
+		}
+		if msg := checkPasswords(GroupData{}, -1, parts[1:]); msg != "" {
+			_ = ctx.SendPlainMessage(msg)
+			return
+		}
+		if registerGroup(s, gid, parts[1:]) != nil {
+			_ = ctx.SendPlainMessage("注册群组失败")
+			return
+		}
+		_ = ctx.SendPlainMessage("已注册本群，口令：" + strings.Join(parts[1:], "、"))
+		return
+	}
+	if !isGroupRegistered(s, gid) {
+		_ = ctx.SendPlainMessage("本群未注册 orderCard")
+		return
+	}
+	if cmd == cmdUnregister {
+		removeGroup(s, gid)
+		_ = ctx.SendPlainMessage("已取消本群的 orderCard 注册")
+		return
+	}
+	data, found := loadGroupData(s, gid)
+	if !found {
+		return
+	}
+	switch cmd {
+	case cmdAddPassword:
+		// {prefix}addOrderCardPassword pw [counter]; the counter may be omitted when the group has only one.
+		if len(parts) < 2 {
+			_ = ctx.SendPlainMessage("用法: " + prefix + cmdAddPassword + " <口令> [计数器名称]")
+			return
+		}
+		pw := parts[1]
+		idx := 0
+		if len(parts) > 2 {
+			if idx = data.counterIndex(parts[2]); idx < 0 {
+				_ = ctx.SendPlainMessage("没有名为 " + parts[2] + " 的计数器")
+				return
+			}
+		} else if len(data.Counters) != 1 {
+			_ = ctx.SendPlainMessage("本群有多个计数器，请指定名称: " + prefix + cmdAddPassword + " <口令> <计数器名称>")
+			return
+		}
+		if msg := checkPassword(data, idx, pw); msg != "" {
+			_ = ctx.SendPlainMessage(msg)
+			return
+		}
+		data.Counters[idx].Passwords = append(data.Counters[idx].Passwords, pw)
+		if saveGroupData(s, gid, data) != nil {
+			_ = ctx.SendPlainMessage("添加口令失败")
+			return
+		}
+		_ = ctx.SendPlainMessage("已为计数器 " + data.Counters[idx].Name + " 添加口令 " + pw)
+	case cmdRemovePassword:
+		if len(parts) < 2 {
+			_ = ctx.SendPlainMessage("用法: " + prefix + cmdRemovePassword + " <口令>")
+			return
+		}
+		pw := parts[1]
+		idx := data.passwordOwner(pw)
+		if idx < 0 {
+			_ = ctx.SendPlainMessage("没有口令 " + pw)
+			return
+		}
+		c := &data.Counters[idx]
+		if len(c.Passwords) == 1 {
+			_ = ctx.SendPlainMessage("计数器 " + c.Name + " 至少需要保留一个口令")
+			return
+		}
+		c.Passwords = slices.DeleteFunc(c.Passwords, func(p string) bool { return p == pw })
+		if saveGroupData(s, gid, data) != nil {
+			_ = ctx.SendPlainMessage("删除口令失败")
+			return
+		}
+		_ = ctx.SendPlainMessage("已删除计数器 " + c.Name + " 的口令 " + pw)
+	case cmdListPasswords:
+		lines := make([]string, 0, len(data.Counters))
+		for _, c := range data.Counters {
+			lines = append(lines, "【"+c.Name+"】"+strings.Join(c.Passwords, "、"))
+		}
+		_ = ctx.SendPlainMessage(strings.Join(lines, "\n"))
+	}
+}
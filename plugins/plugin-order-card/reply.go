@@ -0,0 +1,360 @@
+package pluginordercard
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	"github.com/Hafuunano/Protocol-ConvertTool/protocol"
+)
+
+// Reply commands: group admin or super admin, in a registered group, operate on the current group.
+const (
+	cmdSetFlavor       = "setOrderCardFlavor"
+	cmdRemoveFlavor    = "removeOrderCardFlavor"
+	cmdResetFlavor     = "resetOrderCardFlavor"
+	cmdSetTemplate     = "setOrderCardTemplate"
+	cmdPreviewTemplate = "previewOrderCardTemplate"
+	cmdResetTemplate   = "resetOrderCardTemplate"
+	maxFlavorBands     = 10
+	maxTemplateLen     = 1000
+	maxReplyLen        = 4096 // bytes a rendered reply may reach before rendering is aborted
+	maxCachedTemplates = 256
+)
+
+var (
+	errReplyTooLong = errors.New("渲染结果过长")
+	// templateFuncs are the only functions a reply template may call: comparisons and logic, all cheap.
+	templateFuncs = map[string]bool{"and": true, "or": true, "not": true, "eq": true, "ne": true, "lt": true, "le": true, "gt": true, "ge": true, "len": true}
+
+	templateCacheMu sync.Mutex
+	templateCache   = make(map[string]*template.Template) // template text -> parsed and checked template
+)
+
+// FlavorBand is one headcount band: Text is shown when the value is at least Min and below the next band's Min.
+type FlavorBand struct {
+	Min  int    `json:"min"`
+	Text string `json:"text"`
+}
+
+var defaultFlavorBands = []FlavorBand{
+	{Min: 0, Text: "看起来今天还没有人出勤诶xwx"},
+	{Min: 5, Text: "人有点多，稍微考虑一下再去吧！"},
+	{Min: 11, Text: "呜呜，太多了，不去了"},
+}
+
+// defaultReplyTemplate reproduces the original fixed layout: 当前人数 / 更新时间 / 更新人 (+ estimated wait) + flavor.
+const defaultReplyTemplate = `{{if .ShowName}}【{{.Name}}】
+{{end}}当前人数：{{.Value}}
+{{if .UpdatedAt}}更新时间：{{.UpdatedAt}}
+{{end}}{{if .Updater}}更新人：{{.Updater}}
+{{end}}{{if .Wait}}{{.Wait}}
+{{end}}{{.Flavor}}`
+
+// ReplyData is what reply templates see for one counter.
+type ReplyData struct {
+	Name      string // counter name
+	ShowName  bool   // the group has several counters, or a renamed one
+	Value     int
+	UpdatedAt string // relative, e.g. "5分钟前"; empty if never updated
+	Updater   string // nickname of the last updater
+	Flavor    string // text of the matching flavor band
+	Wait      string // e.g. "预计等待 ~20 分钟"; empty when the counter has no machine setup
+}
+
+func (c GroupConfig) template() string {
+	if c.Template == "" {
+		return defaultReplyTemplate
+	}
+	return c.Template
+}
+
+// parseReplyTemplate parses and checks text, caching the result by text so replies do not re-parse.
+// Templates are admin input run under the group lock, so anything that can loop or blow up is refused:
+// range, define, block and template actions, and every function but comparisons and logic (printf can pad to gigabytes).
+func parseReplyTemplate(text string) (*template.Template, error) {
+	templateCacheMu.Lock()
+	tmpl, ok := templateCache[text]
+	templateCacheMu.Unlock()
+	if ok {
+		return tmpl, nil
+	}
+	if len(text) > maxTemplateLen {
+		return nil, errors.New("模板最长 " + strconv.Itoa(maxTemplateLen) + " 字节")
+	}
+	tmpl, err := template.New("orderCard").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if len(tmpl.Templates()) > 1 {
+		return nil, errors.New("不支持 define/block")
+	}
+	if err := checkTemplateNode(tmpl.Tree.Root); err != nil {
+		return nil, err
+	}
+	templateCacheMu.Lock()
+	if len(templateCache) >= maxCachedTemplates {
+		clear(templateCache)
+	}
+	templateCache[text] = tmpl
+	templateCacheMu.Unlock()
+	return tmpl, nil
+}
+
+// checkTemplateNode walks the parse tree and rejects the constructs parseReplyTemplate does not allow.
+func checkTemplateNode(n parse.Node) error {
+	switch n := n.(type) {
+	case nil:
+		return nil
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, c := range n.Nodes {
+			if err := checkTemplateNode(c); err != nil {
+				return err
+			}
+		}
+	case *parse.ActionNode:
+		return checkTemplateNode(n.Pipe)
+	case *parse.IfNode:
+		return checkBranch(&n.BranchNode)
+	case *parse.WithNode:
+		return checkBranch(&n.BranchNode)
+	case *parse.RangeNode:
+		return errors.New("不支持 range")
+	case *parse.TemplateNode:
+		return errors.New("不支持 template")
+	case *parse.PipeNode:
+		if n == nil {
+			return nil
+		}
+		for _, c := range n.Cmds {
+			if err := checkTemplateNode(c); err != nil {
+				return err
+			}
+		}
+	case *parse.CommandNode:
+		for _, a := range n.Args {
+			if err := checkTemplateNode(a); err != nil {
+				return err
+			}
+		}
+	case *parse.ChainNode:
+		return checkTemplateNode(n.Node)
+	case *parse.IdentifierNode:
+		if !templateFuncs[n.Ident] {
+			return errors.New("不支持函数 " + n.Ident)
+		}
+	}
+	return nil
+}
+
+func checkBranch(b *parse.BranchNode) error {
+	for _, n := range []parse.Node{b.Pipe, b.List, b.ElseList} {
+		if err := checkTemplateNode(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cappedWriter fails once more than max bytes are written, which aborts template execution.
+type cappedWriter struct {
+	buf bytes.Buffer
+	max int
+}
+
+func (w *cappedWriter) Write(p []byte) (int, error) {
+	if w.buf.Len()+len(p) > w.max {
+		return 0, errReplyTooLong
+	}
+	return w.buf.Write(p)
+}
+
+// renderCounter executes the group's template for c.
+func renderCounter(cfg GroupConfig, c Counter, withName bool) (string, error) {
+	d := ReplyData{
+		Name:      c.Name,
+		ShowName:  withName,
+		Value:     c.Value,
+		UpdatedAt: formatUpdatedAt(c.UpdatedAt),
+		Updater:   c.LastUpdaterName,
+		Flavor:    flavorByValue(cfg.FlavorBands, c.Value),
+		Wait:      formatWait(c, c.Value),
+	}
+	tmpl, err := parseReplyTemplate(cfg.template())
+	if err != nil {
+		return "", err
+	}
+	w := &cappedWriter{max: maxReplyLen}
+	if err := tmpl.Execute(w, d); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(w.buf.String()), nil
+}
+
+// formatCounter renders one counter with the group's template, falling back to the default one so a reply is always sent. withName sets ShowName.
+func formatCounter(cfg GroupConfig, c Counter, withName bool) string {
+	msg, err := renderCounter(cfg, c, withName)
+	if err != nil {
+		cfg.Template = ""
+		msg, _ = renderCounter(cfg, c, withName)
+	}
+	return msg
+}
+
+// formatGroup renders every counter of the group in one reply.
+func formatGroup(cfg GroupConfig, data GroupData) string {
+	if len(data.Counters) == 0 {
+		return "本群还没有计数器"
+	}
+	withName := len(data.Counters) > 1 || data.Counters[0].Name != defaultCounterName
+	blocks := make([]string, 0, len(data.Counters))
+	for _, c := range data.Counters {
+		blocks = append(blocks, formatCounter(cfg, c, withName))
+	}
+	return strings.Join(blocks, "\n\n")
+}
+
+// sampleCounter is used to check and preview templates when the group has no counter yet.
+func sampleCounter() Counter {
+	return Counter{Name: defaultCounterName, Value: 3, UpdatedAt: time.Now().Add(-5 * time.Minute).Format(time.RFC3339), LastUpdaterName: "示例"}
+}
+
+// handleReplyCommands handles the flavor band and reply template commands.
+func handleReplyCommands(ctx protocol.Context) {
+	text := strings.TrimSpace(ctx.PlainText())
+	parts := strings.Fields(text)
+	if len(parts) == 0 {
+		return
+	}
+	prefix := ctx.CommandPrefix()
+	cmd, ok := strings.CutPrefix(parts[0], prefix)
+	if !ok {
+		return
+	}
+	switch cmd {
+	case cmdSetFlavor, cmdRemoveFlavor, cmdResetFlavor, cmdSetTemplate, cmdPreviewTemplate, cmdResetTemplate:
+	default:
+		return
+	}
+	gid := ctx.GroupID()
+	if gid == "" || gid == "0" || !isGroupAdmin(ctx) {
+		return
+	}
+	s := getStore()
+	if s == nil || !isGroupRegistered(s, gid) {
+		_ = ctx.SendPlainMessage("本群未注册 orderCard")
+		return
+	}
+	defer lockGroup(gid)()
+	cfg := loadGroupConfig(s, gid)
+	// Template text keeps its line breaks, so take everything after the command word.
+	rest := strings.TrimSpace(strings.TrimPrefix(text, parts[0]))
+	sample := sampleCounter()
+	if data, found := loadGroupData(s, gid); found && len(data.Counters) > 0 {
+		sample = data.Counters[0]
+	}
+	switch cmd {
+	case cmdPreviewTemplate:
+		// {prefix}previewOrderCardTemplate [template]: render the given or current template without saving.
+		if rest != "" {
+			cfg.Template = rest
+		}
+		msg, err := renderCounter(cfg, sample, true)
+		if err != nil {
+			_ = ctx.SendPlainMessage("模板无效：" + err.Error())
+			return
+		}
+		_ = ctx.SendPlainMessage(msg)
+		return
+	case cmdSetTemplate:
+		if rest == "" {
+			_ = ctx.SendPlainMessage("用法: " + prefix + cmdSetTemplate + " <模板>\n可用字段：{{.Name}} {{.ShowName}} {{.Value}} {{.UpdatedAt}} {{.Updater}} {{.Flavor}} {{.Wait}}")
+			return
+		}
+		cfg.Template = rest
+		// Execute once as well: parse succeeds on unknown fields, execution does not.
+		if _, err := renderCounter(cfg, sample, true); err != nil {
+			_ = ctx.SendPlainMessage("模板无效：" + err.Error())
+			return
+		}
+	case cmdResetTemplate:
+		cfg.Template = ""
+	case cmdSetFlavor:
+		// {prefix}setOrderCardFlavor min text...
+		lo, err := strconv.Atoi(firstField(rest))
+		text := strings.TrimSpace(strings.TrimPrefix(rest, firstField(rest)))
+		if err != nil || lo < 0 || text == "" {
+			_ = ctx.SendPlainMessage("用法: " + prefix + cmdSetFlavor + " <最少人数> <文本>，人数不少于该值时显示该文本")
+			return
+		}
+		bands := slices.Clone(cfg.FlavorBands)
+		if len(bands) == 0 {
+			bands = slices.Clone(defaultFlavorBands)
+		}
+		if i := slices.IndexFunc(bands, func(b FlavorBand) bool { return b.Min == lo }); i >= 0 {
+			bands[i].Text = text
+		} else if len(bands) >= maxFlavorBands {
+			_ = ctx.SendPlainMessage("最多 " + strconv.Itoa(maxFlavorBands) + " 个区间")
+			return
+		} else {
+			bands = append(bands, FlavorBand{Min: lo, Text: text})
+		}
+		slices.SortFunc(bands, func(a, b FlavorBand) int { return a.Min - b.Min })
+		cfg.FlavorBands = bands
+	case cmdRemoveFlavor:
+		lo, err := strconv.Atoi(rest)
+		bands := slices.Clone(cfg.FlavorBands)
+		if len(bands) == 0 {
+			bands = slices.Clone(defaultFlavorBands)
+		}
+		i := slices.IndexFunc(bands, func(b FlavorBand) bool { return b.Min == lo })
+		if err != nil || i < 0 {
+			_ = ctx.SendPlainMessage("用法: " + prefix + cmdRemoveFlavor + " <最少人数>，需为已有区间的起点")
+			return
+		}
+		cfg.FlavorBands = slices.Delete(bands, i, i+1)
+		if len(cfg.FlavorBands) == 0 {
+			_ = ctx.SendPlainMessage("至少保留一个区间，可用 " + prefix + cmdResetFlavor + " 恢复默认")
+			return
+		}
+	case cmdResetFlavor:
+		cfg.FlavorBands = nil
+	}
+	if saveGroupConfig(s, gid, cfg) != nil {
+		_ = ctx.SendPlainMessage("保存设置失败")
+		return
+	}
+	_ = ctx.SendPlainMessage("已保存\n" + formatFlavorBands(cfg.FlavorBands) + "\n\n预览：\n" + formatCounter(cfg, sample, true))
+}
+
+func firstField(s string) string {
+	if f := strings.Fields(s); len(f) > 0 {
+		return f[0]
+	}
+	return ""
+}
+
+// formatFlavorBands lists the bands as ranges, e.g. "0-4：...".
+func formatFlavorBands(bands []FlavorBand) string {
+	if len(bands) == 0 {
+		bands = defaultFlavorBands
+	}
+	lines := []string{"人数区间："}
+	for i, b := range bands {
+		r := strconv.Itoa(b.Min) + "+"
+		if i+1 < len(bands) {
+			r = strconv.Itoa(b.Min) + "-" + strconv.Itoa(bands[i+1].Min-1)
+		}
+		lines = append(lines, r+"："+b.Text)
+	}
+	return strings.Join(lines, "\n")
+}
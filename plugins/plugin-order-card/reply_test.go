@@ -0,0 +1,54 @@
+package pluginordercard
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseReplyTemplate(t *testing.T) {
+	cases := []struct {
+		name string
+		text string
+		ok   bool
+	}{
+		{"default", defaultReplyTemplate, true},
+		{"if else", "{{if .ShowName}}{{.Name}} {{end}}{{.Value}}{{if gt .Value 3}} 满{{else}} 缺{{end}}", true},
+		{"with", "{{with .Flavor}}{{.}}{{end}}", true},
+		{"range", "{{range 20000000}}{{range 1000}}x{{end}}{{end}}", false},
+		{"define", `{{define "x"}}y{{end}}{{.Value}}`, false},
+		{"block", `{{block "x" .}}y{{end}}`, false},
+		{"template", `{{template "orderCard" .}}`, false},
+		{"printf", `{{printf "%999999999d" 1}}`, false},
+		{"nested printf", `{{if .Value}}{{.Name | printf "%s"}}{{end}}`, false},
+		{"too long", strings.Repeat("x", maxTemplateLen+1), false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseReplyTemplate(tc.text)
+			if (err == nil) != tc.ok {
+				t.Fatalf("parseReplyTemplate(%q) err = %v, want ok=%v", tc.text, err, tc.ok)
+			}
+		})
+	}
+}
+
+func TestParseReplyTemplateCached(t *testing.T) {
+	a, err := parseReplyTemplate("{{.Value}} 人")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := parseReplyTemplate("{{.Value}} 人")
+	if a != b {
+		t.Fatal("same template text parsed twice")
+	}
+}
+
+func TestRenderCounterCapped(t *testing.T) {
+	cfg := GroupConfig{Template: strings.Repeat("{{.Name}}", 100)}
+	c := sampleCounter()
+	c.Name = strings.Repeat("名", 100)
+	if _, err := renderCounter(cfg, c, true); !errors.Is(err, errReplyTooLong) {
+		t.Fatalf("renderCounter err = %v, want errReplyTooLong", err)
+	}
+}
@@ -0,0 +1,195 @@
+package pluginordercard
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Hafuunano/Core-SkillAction/cache/database"
+)
+
+const (
+	// schedulerStartDelay gives the host time to call SetStore before the first (missed-reset) pass.
+	schedulerStartDelay = 30 * time.Second
+	// maxSchedulerSleep bounds each wait so config changes are picked up without a restart.
+	maxSchedulerSleep = 10 * time.Minute
+)
+
+// Clock is the time source of the reset scheduler. Tests can pass a fake to StartResetScheduler.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type wallClock struct{}
+
+func (wallClock) Now() time.Time                         { return time.Now() }
+func (wallClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+var (
+	schedulerMu     sync.Mutex
+	schedulerCancel context.CancelFunc
+	schedulerDone   chan struct{}
+)
+
+// StartResetScheduler starts (or restarts) the background reset loop using clock; nil means the wall clock.
+// Resets missed while the process was down run on the first pass.
+func StartResetScheduler(clock Clock) {
+	StopResetScheduler()
+	if clock == nil {
+		clock = wallClock{}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	schedulerMu.Lock()
+	schedulerCancel, schedulerDone = cancel, done
+	schedulerMu.Unlock()
+	go func() {
+		defer close(done)
+		runResetLoop(ctx, clock)
+	}()
+}
+
+// StopResetScheduler stops the reset loop and waits for it to exit. Safe to call when it is not running.
+func StopResetScheduler() {
+	schedulerMu.Lock()
+	cancel, done := schedulerCancel, schedulerDone
+	schedulerCancel, schedulerDone = nil, nil
+	schedulerMu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+func runResetLoop(ctx context.Context, clock Clock) {
+	wait := schedulerStartDelay
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-clock.After(wait):
+		}
+		now := clock.Now()
+		wait = maxSchedulerSleep
+		if s := getStore(); s != nil {
+			if next, ok := runResets(s, now); ok {
+				wait = min(wait, max(next.Sub(now), time.Second))
+			}
+		}
+	}
+}
+
+// runResets resets every registered group whose scheduled reset has passed since its last reset, and returns the earliest upcoming reset.
+func runResets(s *database.Store, now time.Time) (next time.Time, ok bool) {
+	for _, gid := range registeredGroups(s) {
+		if n, has := resetIfDue(s, gid, now); has && (!ok || n.Before(next)) {
+			next, ok = n, true
+		}
+	}
+	return next, ok
+}
+
+// resetIfDue resets gid under its group lock when due, and returns the group's next scheduled reset.
+func resetIfDue(s *database.Store, gid string, now time.Time) (time.Time, bool) {
+	defer lockGroup(gid)()
+	data, found := loadGroupData(s, gid)
+	if !found {
+		return time.Time{}, false
+	}
+	cfg := loadGroupConfig(s, gid)
+	if at, due := resetDue(cfg, data, now); due {
+		resetGroup(s, gid, data, cfg, at)
+	}
+	return nextScheduledReset(cfg, now)
+}
+
+// resetGroup archives the period since the group's last reset and zeroes counter values (keep updatedAt, lastUpdaterName, passwords).
+func resetGroup(s *database.Store, gid string, data GroupData, cfg GroupConfig, at time.Time) {
+	start := at.AddDate(0, 0, -1)
+	if t, err := time.Parse(time.RFC3339, data.LastResetAt); err == nil {
+		start = t
+	}
+	archiveDay(s, gid, data, start, cfg.location())
+	for i := range data.Counters {
+		data.Counters[i].Value = 0
+	}
+	data.LastResetAt = at.Format(time.RFC3339)
+	_ = saveGroupData(s, gid, data)
+}
+
+// resetDue returns the scheduled reset to apply when one has passed since the group's last reset.
+// Records without lastResetAt fall back to the newest counter UpdatedAt, so stale values still reset after an upgrade.
+func resetDue(cfg GroupConfig, data GroupData, now time.Time) (time.Time, bool) {
+	last, ok := lastScheduledReset(cfg, now)
+	if !ok {
+		return time.Time{}, false
+	}
+	ref := data.LastResetAt
+	if ref == "" {
+		for _, c := range data.Counters {
+			if c.UpdatedAt > ref {
+				ref = c.UpdatedAt
+			}
+		}
+	}
+	if ref == "" {
+		return last, true
+	}
+	t, err := time.Parse(time.RFC3339, ref)
+	if err != nil || t.Before(last) {
+		return last, true
+	}
+	return time.Time{}, false
+}
+
+// lastScheduledReset returns the most recent reset at or before now, skipping SkipWeekdays. False if every weekday is skipped.
+func lastScheduledReset(cfg GroupConfig, now time.Time) (time.Time, bool) {
+	now = now.In(cfg.location())
+	off := 0
+	if now.Before(resetOn(cfg, now, 0)) {
+		off = -1
+	}
+	for i := range 7 {
+		if t := resetOn(cfg, now, off-i); !cfg.skips(t.Weekday()) {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// nextScheduledReset returns the first reset after now, skipping SkipWeekdays. False if every weekday is skipped.
+func nextScheduledReset(cfg GroupConfig, now time.Time) (time.Time, bool) {
+	now = now.In(cfg.location())
+	off := 0
+	if !now.Before(resetOn(cfg, now, 0)) {
+		off = 1
+	}
+	for i := range 7 {
+		if t := resetOn(cfg, now, off+i); !cfg.skips(t.Weekday()) {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// resetOn returns the reset time days after now's date. Each day is built from its date rather than stepped
+// with AddDate, so an hour moved by a DST gap on one day does not carry over to the others.
+func resetOn(cfg GroupConfig, now time.Time, days int) time.Time {
+	y, m, d := now.Year(), now.Month(), now.Day()+days
+	t := time.Date(y, m, d, cfg.ResetHour, 0, 0, 0, now.Location())
+	if t.Hour() != cfg.ResetHour {
+		// The reset hour falls in a DST gap, which time.Date may resolve to before it: reset when the clock jumps past it.
+		t = time.Date(y, m, d, cfg.ResetHour+1, 0, 0, 0, now.Location())
+	}
+	return t
+}
+
+// dayStart returns the start of the current counting period: the last scheduled reset, or 24h ago if resets are all skipped.
+func dayStart(cfg GroupConfig, now time.Time) time.Time {
+	if t, ok := lastScheduledReset(cfg, now); ok {
+		return t
+	}
+	return now.Add(-24 * time.Hour)
+}
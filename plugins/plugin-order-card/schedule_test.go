@@ -0,0 +1,279 @@
+package pluginordercard
+
+import (
+	"sync"
+	"testing"
+	"time"
+	_ "time/tzdata" // the cases below need real zones regardless of the host's zoneinfo
+
+	"github.com/Hafuunano/Core-SkillAction/cache/database"
+)
+
+func mustLoc(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+func TestScheduledResets(t *testing.T) {
+	shanghai := mustLoc(t, "Asia/Shanghai")
+	newYork := mustLoc(t, "America/New_York")
+	berlin := mustLoc(t, "Europe/Berlin")
+	at := func(loc *time.Location, y int, m time.Month, d, h, min int) time.Time {
+		return time.Date(y, m, d, h, min, 0, 0, loc)
+	}
+	weekend := []time.Weekday{time.Saturday, time.Sunday}
+	cases := []struct {
+		name       string
+		cfg        GroupConfig
+		now        time.Time
+		last, next time.Time
+		ok         bool
+	}{
+		{
+			name: "before the reset hour",
+			cfg:  GroupConfig{ResetHour: 4, Timezone: "Asia/Shanghai"},
+			now:  at(shanghai, 2026, 10, 18, 3, 59),
+			last: at(shanghai, 2026, 10, 17, 4, 0), next: at(shanghai, 2026, 10, 18, 4, 0), ok: true,
+		},
+		{
+			name: "exactly at the reset hour",
+			cfg:  GroupConfig{ResetHour: 4, Timezone: "Asia/Shanghai"},
+			now:  at(shanghai, 2026, 10, 18, 4, 0),
+			last: at(shanghai, 2026, 10, 18, 4, 0), next: at(shanghai, 2026, 10, 19, 4, 0), ok: true,
+		},
+		{
+			name: "now in UTC, reset hour in Shanghai",
+			cfg:  GroupConfig{ResetHour: 4, Timezone: "Asia/Shanghai"},
+			now:  time.Date(2026, 10, 17, 20, 30, 0, 0, time.UTC), // 04:30 on the 18th in Shanghai
+			last: at(shanghai, 2026, 10, 18, 4, 0), next: at(shanghai, 2026, 10, 19, 4, 0), ok: true,
+		},
+		{
+			name: "UTC date still the previous day",
+			cfg:  GroupConfig{ResetHour: 4, Timezone: "Asia/Shanghai"},
+			now:  time.Date(2026, 10, 17, 19, 0, 0, 0, time.UTC), // 03:00 on the 18th in Shanghai
+			last: at(shanghai, 2026, 10, 17, 4, 0), next: at(shanghai, 2026, 10, 18, 4, 0), ok: true,
+		},
+		{
+			name: "skipped weekend seen from Sunday",
+			cfg:  GroupConfig{ResetHour: 4, Timezone: "Asia/Shanghai", SkipWeekdays: weekend},
+			now:  at(shanghai, 2026, 10, 18, 10, 0),
+			last: at(shanghai, 2026, 10, 16, 4, 0), next: at(shanghai, 2026, 10, 19, 4, 0), ok: true,
+		},
+		{
+			name: "skipped weekend seen from Friday",
+			cfg:  GroupConfig{ResetHour: 4, Timezone: "Asia/Shanghai", SkipWeekdays: weekend},
+			now:  at(shanghai, 2026, 10, 16, 10, 0),
+			last: at(shanghai, 2026, 10, 16, 4, 0), next: at(shanghai, 2026, 10, 19, 4, 0), ok: true,
+		},
+		{
+			name: "every weekday skipped",
+			cfg: GroupConfig{ResetHour: 4, Timezone: "Asia/Shanghai", SkipWeekdays: []time.Weekday{
+				time.Sunday, time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday,
+			}},
+			now: at(shanghai, 2026, 10, 18, 10, 0),
+		},
+		{
+			name: "midnight reset across the day boundary",
+			cfg:  GroupConfig{ResetHour: 0, Timezone: "Europe/Berlin"},
+			now:  at(berlin, 2026, 10, 18, 23, 30),
+			last: at(berlin, 2026, 10, 18, 0, 0), next: at(berlin, 2026, 10, 19, 0, 0), ok: true,
+		},
+		{
+			name: "DST ends overnight",
+			cfg:  GroupConfig{ResetHour: 4, Timezone: "Europe/Berlin"},
+			now:  at(berlin, 2026, 10, 25, 3, 30), // clocks went back at 03:00 CEST
+			last: at(berlin, 2026, 10, 24, 4, 0), next: at(berlin, 2026, 10, 25, 4, 0), ok: true,
+		},
+		{
+			name: "reset hour inside the spring-forward gap",
+			cfg:  GroupConfig{ResetHour: 2, Timezone: "America/New_York"},
+			now:  at(newYork, 2026, 3, 8, 12, 0),
+			last: at(newYork, 2026, 3, 8, 3, 0), next: at(newYork, 2026, 3, 9, 2, 0), ok: true,
+		},
+		{
+			name: "skipping the gap day keeps the reset hour",
+			cfg:  GroupConfig{ResetHour: 2, Timezone: "America/New_York", SkipWeekdays: []time.Weekday{time.Sunday}},
+			now:  at(newYork, 2026, 3, 9, 1, 0),
+			last: at(newYork, 2026, 3, 7, 2, 0), next: at(newYork, 2026, 3, 9, 2, 0), ok: true,
+		},
+		{
+			name: "skipping the gap day forwards",
+			cfg:  GroupConfig{ResetHour: 2, Timezone: "America/New_York", SkipWeekdays: []time.Weekday{time.Sunday}},
+			now:  at(newYork, 2026, 3, 7, 12, 0),
+			last: at(newYork, 2026, 3, 7, 2, 0), next: at(newYork, 2026, 3, 9, 2, 0), ok: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			last, ok := lastScheduledReset(tc.cfg, tc.now)
+			if ok != tc.ok || !last.Equal(tc.last) {
+				t.Errorf("lastScheduledReset = %v, %v; want %v, %v", last, ok, tc.last, tc.ok)
+			}
+			next, ok := nextScheduledReset(tc.cfg, tc.now)
+			if ok != tc.ok || !next.Equal(tc.next) {
+				t.Errorf("nextScheduledReset = %v, %v; want %v, %v", next, ok, tc.next, tc.ok)
+			}
+		})
+	}
+}
+
+func TestResetDue(t *testing.T) {
+	shanghai := mustLoc(t, "Asia/Shanghai")
+	cfg := GroupConfig{ResetHour: 4, Timezone: "Asia/Shanghai"}
+	now := time.Date(2026, 10, 18, 10, 0, 0, 0, shanghai)
+	last := time.Date(2026, 10, 18, 4, 0, 0, 0, shanghai)
+	stamp := func(d time.Duration) string { return last.Add(d).Format(time.RFC3339) }
+	cases := []struct {
+		name string
+		data GroupData
+		due  bool
+	}{
+		{"reset already applied", GroupData{LastResetAt: stamp(0)}, false},
+		{"last reset yesterday", GroupData{LastResetAt: stamp(-24 * time.Hour)}, true},
+		{"three days of downtime", GroupData{LastResetAt: stamp(-72 * time.Hour)}, true},
+		{"no record at all", GroupData{Counters: []Counter{{Name: "a"}}}, true},
+		{"upgrade: updated before the reset", GroupData{Counters: []Counter{{UpdatedAt: stamp(-time.Hour)}}}, true},
+		{"upgrade: updated after the reset", GroupData{Counters: []Counter{{UpdatedAt: stamp(-time.Hour)}, {UpdatedAt: stamp(time.Hour)}}}, false},
+		{"unparsable record", GroupData{LastResetAt: "yesterday"}, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			at, due := resetDue(cfg, tc.data, now)
+			if due != tc.due {
+				t.Fatalf("resetDue due = %v, want %v", due, tc.due)
+			}
+			// However long the downtime, the reset applied is the latest one only.
+			if due && !at.Equal(last) {
+				t.Errorf("resetDue at = %v, want %v", at, last)
+			}
+		})
+	}
+}
+
+// fakeClock is a Clock whose time only moves when the test sets it. Each After call is
+// handed to the test on waits, which fires it to run one scheduler pass.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waits   chan chan time.Time
+	pending chan time.Time // the wait the scheduler is blocked on, once a pass has run
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = t
+}
+
+func (c *fakeClock) After(time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	c.waits <- ch
+	return ch
+}
+
+// pass fires the scheduler's pending wait and returns once the pass has run and it waits again.
+func (c *fakeClock) pass(t *testing.T) {
+	t.Helper()
+	if c.pending == nil {
+		c.pending = c.nextWait(t)
+	}
+	c.pending <- c.Now()
+	c.pending = c.nextWait(t)
+}
+
+func (c *fakeClock) nextWait(t *testing.T) chan time.Time {
+	t.Helper()
+	select {
+	case ch := <-c.waits:
+		return ch
+	case <-time.After(5 * time.Second):
+		t.Fatal("scheduler is not waiting")
+		return nil
+	}
+}
+
+// useStore installs s for the scheduler and restores the previous store when the test ends.
+func useStore(t *testing.T, s *database.Store) {
+	storeMu.Lock()
+	prev := store
+	store = s
+	storeMu.Unlock()
+	storeInitOnce.Do(func() {}) // keep getStore from replacing s with the default cache
+	t.Cleanup(func() {
+		StopResetScheduler()
+		SetStore(prev)
+	})
+}
+
+func TestSchedulerRunsMissedResetOnce(t *testing.T) {
+	shanghai := mustLoc(t, "Asia/Shanghai")
+	s := newTestStore(t, 0)
+	useStore(t, s)
+	const gid = "30001"
+	if err := registerGroup(s, gid, []string{"pw"}); err != nil {
+		t.Fatal(err)
+	}
+	down := time.Date(2026, 10, 15, 12, 0, 0, 0, shanghai)
+	data, _ := loadGroupData(s, gid)
+	data.LastResetAt = time.Date(2026, 10, 15, 4, 0, 0, 0, shanghai).Format(time.RFC3339)
+	data.Counters[0].Value = 7
+	data.Counters[0].UpdatedAt = down.Format(time.RFC3339)
+	if err := saveGroupData(s, gid, data); err != nil {
+		t.Fatal(err)
+	}
+
+	// Back up three days and six hours later: three resets were missed.
+	clock := &fakeClock{now: down.Add(78 * time.Hour), waits: make(chan chan time.Time)}
+	StartResetScheduler(clock)
+	for i := range 3 {
+		clock.pass(t)
+		if n := len(loadArchive(s, gid)); n != 1 {
+			t.Fatalf("pass %d: %d archived periods, want 1", i+1, n)
+		}
+	}
+	data, _ = loadGroupData(s, gid)
+	want := time.Date(2026, 10, 18, 4, 0, 0, 0, shanghai).Format(time.RFC3339)
+	if data.Counters[0].Value != 0 || data.LastResetAt != want {
+		t.Fatalf("after catch-up: value %d, lastResetAt %s; want 0, %s", data.Counters[0].Value, data.LastResetAt, want)
+	}
+
+	// The next scheduled reset runs once more.
+	clock.set(time.Date(2026, 10, 19, 4, 0, 0, 0, shanghai))
+	clock.pass(t)
+	clock.pass(t)
+	if n := len(loadArchive(s, gid)); n != 2 {
+		t.Fatalf("after the next reset: %d archived periods, want 2", n)
+	}
+}
+
+func TestResetIfDueReturnsNextReset(t *testing.T) {
+	shanghai := mustLoc(t, "Asia/Shanghai")
+	s := newTestStore(t, 0)
+	const gid = "30002"
+	if err := registerGroup(s, gid, []string{"pw"}); err != nil {
+		t.Fatal(err)
+	}
+	cfg := loadGroupConfig(s, gid)
+	cfg.SkipWeekdays = []time.Weekday{time.Monday}
+	if err := saveGroupConfig(s, gid, cfg); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 10, 18, 10, 0, 0, 0, shanghai) // Sunday; Monday is skipped
+	next, ok := resetIfDue(s, gid, now)
+	if want := time.Date(2026, 10, 20, 4, 0, 0, 0, shanghai); !ok || !next.Equal(want) {
+		t.Fatalf("resetIfDue next = %v, %v; want %v", next, ok, want)
+	}
+	if _, ok := resetIfDue(s, "30009", now); ok {
+		t.Fatal("resetIfDue reported a reset for an unregistered group")
+	}
+}
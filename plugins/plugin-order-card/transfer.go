@@ -0,0 +1,313 @@
+package pluginordercard
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Hafuunano/Core-SkillAction/cache/database"
+	"github.com/Hafuunano/Protocol-ConvertTool/protocol"
+)
+
+// Transfer commands: super admin only, from any chat.
+const (
+	cmdExport = "exportOrderCard"
+	cmdImport = "importOrderCard"
+	// exportVersion is bumped whenever ExportDocument changes incompatibly.
+	exportVersion = 1
+)
+
+// ImportMode decides what happens when an imported group is already registered.
+type ImportMode string
+
+const (
+	ImportSkip      ImportMode = "skip"      // keep the existing group untouched
+	ImportOverwrite ImportMode = "overwrite" // replace data and config with the imported ones
+	ImportMerge     ImportMode = "merge"     // keep existing counters and values, add missing counters and passwords
+)
+
+// ExportDocument is the JSON produced by Export and accepted by Import.
+type ExportDocument struct {
+	Version    int             `json:"version"`
+	ExportedAt string          `json:"exportedAt"` // RFC3339
+	Groups     []ExportedGroup `json:"groups"`
+}
+
+// ExportedGroup is one registered group with its counters and, when customized, its config.
+type ExportedGroup struct {
+	GroupID string       `json:"groupId"`
+	Data    GroupData    `json:"data"`
+	Config  *GroupConfig `json:"config,omitempty"`
+}
+
+// ImportResult reports what Import did with one group.
+type ImportResult struct {
+	GroupID string `json:"groupId"`
+	Status  string `json:"status"` // created, skipped, overwritten, merged or invalid
+	Error   string `json:"error,omitempty"`
+}
+
+// Export returns every registered group as an ExportDocument in JSON.
+func Export() ([]byte, error) {
+	s := getStore()
+	if s == nil {
+		return nil, errors.New("orderCard: store not set")
+	}
+	doc := ExportDocument{Version: exportVersion, ExportedAt: time.Now().Format(time.RFC3339), Groups: []ExportedGroup{}}
+	for _, gid := range registeredGroups(s) {
+		if g, ok := exportGroup(s, gid); ok {
+			doc.Groups = append(doc.Groups, g)
+		}
+	}
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+func exportGroup(s *database.Store, gid string) (ExportedGroup, bool) {
+	defer lockGroup(gid)()
+	data, found := loadGroupData(s, gid)
+	if !found {
+		return ExportedGroup{}, false
+	}
+	g := ExportedGroup{GroupID: gid, Data: data}
+	if _, found, _ := s.Get(keyPrefixConfig + gid); found {
+		cfg := loadGroupConfig(s, gid)
+		g.Config = &cfg
+	}
+	return g, true
+}
+
+// Import registers the groups of an ExportDocument. Each group is validated and applied on its own,
+// so one bad group does not stop the rest; the error is only for an unreadable document or bad mode.
+func Import(raw []byte, mode ImportMode) ([]ImportResult, error) {
+	if mode != ImportSkip && mode != ImportOverwrite && mode != ImportMerge {
+		return nil, errors.New("orderCard: unknown import mode " + strconv.Quote(string(mode)))
+	}
+	s := getStore()
+	if s == nil {
+		return nil, errors.New("orderCard: store not set")
+	}
+	var doc ExportDocument
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Version != exportVersion {
+		return nil, errors.New("orderCard: unsupported export version " + strconv.Itoa(doc.Version))
+	}
+	results := make([]ImportResult, 0, len(doc.Groups))
+	seen := make(map[string]bool, len(doc.Groups))
+	for _, g := range doc.Groups {
+		res := ImportResult{GroupID: g.GroupID}
+		if msg := validateExportedGroup(g); msg != "" {
+			res.Status, res.Error = "invalid", msg
+		} else if seen[g.GroupID] {
+			res.Status, res.Error = "invalid", "群号重复"
+		} else {
+			res.Status, res.Error = importGroup(s, g, mode)
+		}
+		seen[g.GroupID] = true
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+// validateExportedGroup returns why g cannot be imported, or "".
+func validateExportedGroup(g ExportedGroup) string {
+	if g.GroupID == "" || g.GroupID == "0" || strings.ContainsAny(g.GroupID, " \t\n") {
+		return "群号无效"
+	}
+	if len(g.Data.Counters) == 0 {
+		return "没有计数器"
+	}
+	var checked GroupData
+	for i, c := range g.Data.Counters {
+		if c.Name == "" || checked.counterIndex(c.Name) >= 0 {
+			return "计数器名称为空或重复"
+		}
+		if c.Value < 0 {
+			return "计数器 " + c.Name + " 人数为负数"
+		}
+		if c.Machines < 0 || c.Machines > maxMachines || c.AvgPlayMinutes < 0 || c.AvgPlayMinutes > maxPlayMinutes {
+			return "计数器 " + c.Name + " 机台设置无效"
+		}
+		if msg := checkPasswords(checked, -1, c.Passwords); msg != "" {
+			return "计数器 " + c.Name + "：" + msg
+		}
+		checked.Counters = append(checked.Counters, g.Data.Counters[i])
+	}
+	if cfg := g.Config; cfg != nil {
+		if cfg.ResetHour < 0 || cfg.ResetHour > 23 {
+			return "重置时间无效"
+		}
+		if _, err := time.LoadLocation(cfg.Timezone); err != nil {
+			return "时区无效: " + cfg.Timezone
+		}
+		if cfg.MinValue < 0 || cfg.MaxValue < 0 || (cfg.MaxValue > 0 && cfg.MaxValue < cfg.MinValue) || cfg.MaxDelta < 0 {
+			return "人数范围无效"
+		}
+		if !isValidMultiOpPolicy(cfg.MultiOpPolicy) {
+			return "多操作策略无效: " + cfg.MultiOpPolicy
+		}
+		if cfg.CooldownSeconds < 0 || cfg.CooldownSeconds > maxCooldownSeconds {
+			return "冷却时间无效"
+		}
+		if cfg.Template != "" {
+			if _, err := parseReplyTemplate(cfg.Template); err != nil {
+				return "模板无效：" + err.Error()
+			}
+		}
+	}
+	return ""
+}
+
+// importGroup applies one validated group under its group lock.
+func importGroup(s *database.Store, g ExportedGroup, mode ImportMode) (status, errMsg string) {
+	gid := g.GroupID
+	defer lockGroup(gid)()
+	if g.Config != nil && g.Config.Alias != "" {
+		if owner, ok := groupByAlias(s, g.Config.Alias); ok && owner != gid {
+			return "invalid", "别名 " + g.Config.Alias + " 已被群 " + owner + " 使用"
+		}
+	}
+	existing, found := loadGroupData(s, gid)
+	registered := isGroupRegistered(s, gid) && found
+	data, status := g.Data, "created"
+	switch {
+	case !registered:
+	case mode == ImportSkip:
+		return "skipped", ""
+	case mode == ImportOverwrite:
+		status = "overwritten"
+	case mode == ImportMerge:
+		data, status = mergeGroupData(existing, g.Data), "merged"
+	}
+	if err := s.Set(keyPrefixGroup+gid, "1"); err != nil {
+		return "invalid", err.Error()
+	}
+	if err := indexGroup(s, gid); err != nil {
+		return "invalid", err.Error()
+	}
+	if data.LastResetAt == "" {
+		data.LastResetAt = time.Now().Format(time.RFC3339)
+	}
+	if err := saveGroupData(s, gid, data); err != nil {
+		return "invalid", err.Error()
+	}
+	// Merge keeps an existing config; otherwise the imported one wins, and overwriting with none restores the defaults.
+	switch {
+	case g.Config != nil:
+		if _, has, _ := s.Get(keyPrefixConfig + gid); !has || status != "merged" {
+			if err := saveGroupConfig(s, gid, *g.Config); err != nil {
+				return "invalid", err.Error()
+			}
+		}
+	case status == "overwritten":
+		if err := s.Delete(keyPrefixConfig + gid); err != nil {
+			return "invalid", err.Error()
+		}
+	}
+	return status, ""
+}
+
+// mergeGroupData keeps every existing counter and value, adds imported passwords to counters of the same name,
+// and appends imported counters that do not exist yet. Passwords already used by another counter are dropped.
+func mergeGroupData(existing, imported GroupData) GroupData {
+	for _, ic := range imported.Counters {
+		i := existing.counterIndex(ic.Name)
+		if i < 0 {
+			ic.Passwords = freePasswords(existing, ic.Passwords)
+			if len(ic.Passwords) > 0 {
+				existing.Counters = append(existing.Counters, ic)
+			}
+			continue
+		}
+		for _, pw := range freePasswords(existing, ic.Passwords) {
+			if len(existing.Counters[i].Passwords) < maxPasswordsPerCounter {
+				existing.Counters[i].Passwords = append(existing.Counters[i].Passwords, pw)
+			}
+		}
+	}
+	return existing
+}
+
+// freePasswords returns the passwords no counter of data uses yet.
+func freePasswords(data GroupData, passwords []string) []string {
+	var out []string
+	for _, pw := range passwords {
+		if data.passwordOwner(pw) < 0 {
+			out = append(out, pw)
+		}
+	}
+	return out
+}
+
+// handleTransferCommands handles exportOrderCard and importOrderCard <skip|overwrite|merge> <json>.
+// The documents carry every group's passwords, so both only work in private chat; in a group export only sends a summary.
+func handleTransferCommands(ctx protocol.Context) {
+	text := strings.TrimSpace(ctx.PlainText())
+	parts := strings.Fields(text)
+	if len(parts) == 0 {
+		return
+	}
+	prefix := ctx.CommandPrefix()
+	if parts[0] != prefix+cmdExport && parts[0] != prefix+cmdImport {
+		return
+	}
+	if gid := ctx.GroupID(); gid != "" && gid != "0" {
+		msg := "导入导出的数据包含所有群的口令，请私聊使用 " + parts[0]
+		if s := getStore(); s != nil && parts[0] == prefix+cmdExport {
+			msg = "当前共有 " + strconv.Itoa(len(registeredGroups(s))) + " 个已注册群组\n" + msg
+		}
+		_ = ctx.SendPlainMessage(msg)
+		return
+	}
+	switch parts[0] {
+	case prefix + cmdExport:
+		raw, err := Export()
+		if err != nil {
+			_ = ctx.SendPlainMessage("导出失败：" + err.Error())
+			return
+		}
+		_ = ctx.SendPlainMessage(string(raw))
+	case prefix + cmdImport:
+		usage := "用法: " + prefix + cmdImport + " <skip|overwrite|merge> <导出的 JSON>"
+		if len(parts) < 3 {
+			_ = ctx.SendPlainMessage(usage)
+			return
+		}
+		mode := ImportMode(parts[1])
+		// The JSON may span lines, so take everything after the mode word.
+		body := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(strings.TrimPrefix(text, parts[0])), parts[1]))
+		results, err := Import([]byte(body), mode)
+		if err != nil {
+			_ = ctx.SendPlainMessage("导入失败：" + err.Error() + "\n" + usage)
+			return
+		}
+		_ = ctx.SendPlainMessage(formatImportResults(results))
+	}
+}
+
+var importStatusLabels = map[string]string{
+	"created":     "已创建",
+	"skipped":     "已跳过",
+	"overwritten": "已覆盖",
+	"merged":      "已合并",
+	"invalid":     "失败",
+}
+
+func formatImportResults(results []ImportResult) string {
+	if len(results) == 0 {
+		return "导入文档中没有群组"
+	}
+	lines := make([]string, 0, len(results)+1)
+	lines = append(lines, "导入结果（"+strconv.Itoa(len(results))+" 个群组）：")
+	for _, r := range results {
+		line := r.GroupID + "：" + importStatusLabels[r.Status]
+		if r.Error != "" {
+			line += "（" + r.Error + "）"
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,60 @@
+package pluginordercard
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestImportConfigByMode(t *testing.T) {
+	const gid = "40001"
+	custom, imported := defaultGroupConfig(), defaultGroupConfig()
+	custom.ResetHour, imported.ResetHour = 6, 9
+	cases := []struct {
+		name   string
+		mode   ImportMode
+		config *GroupConfig // config in the imported document
+		status string
+		want   int // ResetHour after the import
+		kept   bool
+	}{
+		{"skip", ImportSkip, nil, "skipped", custom.ResetHour, true},
+		{"merge without config", ImportMerge, nil, "merged", custom.ResetHour, true},
+		{"merge keeps existing config", ImportMerge, &imported, "merged", custom.ResetHour, true},
+		{"overwrite with config", ImportOverwrite, &imported, "overwritten", imported.ResetHour, true},
+		{"overwrite without config restores defaults", ImportOverwrite, nil, "overwritten", defaultResetHour, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestStore(t, 0)
+			useStore(t, s)
+			if err := registerGroup(s, gid, []string{"pw"}); err != nil {
+				t.Fatal(err)
+			}
+			if err := saveGroupConfig(s, gid, custom); err != nil {
+				t.Fatal(err)
+			}
+			doc := ExportDocument{Version: exportVersion, Groups: []ExportedGroup{{
+				GroupID: gid,
+				Data:    GroupData{Counters: []Counter{{Name: defaultCounterName, Passwords: []string{"new"}}}},
+				Config:  tc.config,
+			}}}
+			raw, err := json.Marshal(doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			results, err := Import(raw, tc.mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(results) != 1 || results[0].Status != tc.status {
+				t.Fatalf("Import results = %+v, want status %s", results, tc.status)
+			}
+			if got := loadGroupConfig(s, gid).ResetHour; got != tc.want {
+				t.Errorf("ResetHour = %d, want %d", got, tc.want)
+			}
+			if _, has, _ := s.Get(keyPrefixConfig + gid); has != tc.kept {
+				t.Errorf("config stored = %v, want %v", has, tc.kept)
+			}
+		})
+	}
+}
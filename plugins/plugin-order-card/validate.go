@@ -0,0 +1,95 @@
+package pluginordercard
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Policies for a message carrying several +n/-n/=n operators.
+const (
+	multiOpAll    = "all"    // apply every operator in order
+	multiOpFirst  = "first"  // apply only the first operator
+	multiOpLast   = "last"   // apply only the last operator
+	multiOpReject = "reject" // reject the whole message
+)
+
+const (
+	defaultMinValue      = 0
+	defaultMaxValue      = 100
+	defaultMaxDelta      = 30
+	defaultMultiOpPolicy = multiOpReject
+)
+
+func isValidMultiOpPolicy(p string) bool {
+	return p == multiOpAll || p == multiOpFirst || p == multiOpLast || p == multiOpReject
+}
+
+// opOutcome is the result of validating one message's operators against the group's bounds.
+type opOutcome struct {
+	Value    int      // counter value after the operators
+	Applied  []string // operators taken into account, e.g. "+2"
+	Flags    []string // why the change is suspicious; admins override these, others are rejected
+	Rejected string   // non-empty when the change is not applied
+}
+
+// applyOps validates ops (opRegex submatches) against cfg and computes the new value from current.
+// Numbers that do not parse and negative results are always rejected; out-of-range values and oversized deltas are flagged and only admins may apply them.
+func applyOps(cfg GroupConfig, current int, ops [][]string, isAdmin bool) opOutcome {
+	out := opOutcome{Value: current}
+	if len(ops) > 1 {
+		switch cfg.MultiOpPolicy {
+		case multiOpFirst:
+			ops = ops[:1]
+		case multiOpLast:
+			ops = ops[len(ops)-1:]
+		case multiOpAll:
+		default:
+			out.Rejected = "一条消息只能包含一个 +n/-n/=n"
+			return out
+		}
+	}
+	for _, m := range ops {
+		if len(m) != 3 {
+			continue
+		}
+		op, numStr := m[1], m[2]
+		n, err := strconv.Atoi(numStr)
+		if err != nil {
+			out.Rejected = "数字 " + numStr + " 无效"
+			return out
+		}
+		prev := out.Value
+		switch op {
+		case "+":
+			out.Value += n
+		case "-":
+			out.Value -= n
+		case "=":
+			out.Value = n
+		default:
+			continue
+		}
+		out.Applied = append(out.Applied, op+numStr)
+		if d := out.Value - prev; cfg.MaxDelta > 0 && (d > cfg.MaxDelta || -d > cfg.MaxDelta) {
+			out.Flags = append(out.Flags, "单次变化 "+strconv.Itoa(d)+" 超过上限 "+strconv.Itoa(cfg.MaxDelta))
+		}
+	}
+	if out.Value < 0 {
+		out.Rejected = "人数不能为负数"
+		return out
+	}
+	if out.Value < cfg.MinValue || (cfg.MaxValue > 0 && out.Value > cfg.MaxValue) {
+		out.Flags = append(out.Flags, "结果 "+strconv.Itoa(out.Value)+" 超出范围 "+formatBounds(cfg))
+	}
+	if len(out.Flags) > 0 && !isAdmin {
+		out.Rejected = strings.Join(out.Flags, "；") + "，如确有需要请联系管理员修改"
+	}
+	return out
+}
+
+func formatBounds(cfg GroupConfig) string {
+	if cfg.MaxValue <= 0 {
+		return "[" + strconv.Itoa(cfg.MinValue) + ", 不限]"
+	}
+	return "[" + strconv.Itoa(cfg.MinValue) + ", " + strconv.Itoa(cfg.MaxValue) + "]"
+}
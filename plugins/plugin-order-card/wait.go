@@ -0,0 +1,175 @@
+package pluginordercard
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Hafuunano/Protocol-ConvertTool/protocol"
+)
+
+// Wait commands: setOrderCardMachines for group admins or super admins; orderCardWait and orderCardPredict for everyone.
+const (
+	cmdSetMachines = "setOrderCardMachines"
+	cmdWait        = "orderCardWait"
+	cmdPredict     = "orderCardPredict"
+	maxMachines    = 100
+	maxPlayMinutes = 240
+)
+
+// estimateWait returns the minutes a newcomer waits behind value players, assuming every machine is busy
+// and players rotate every AvgPlayMinutes. ok is false when the counter has no machine setup.
+func estimateWait(c Counter, value int) (minutes int, ok bool) {
+	if c.Machines <= 0 || c.AvgPlayMinutes <= 0 {
+		return 0, false
+	}
+	queue := value - c.Machines
+	if queue < 0 {
+		return 0, true
+	}
+	// A newcomer waits for the queue ahead plus one rotation for a machine to free up.
+	rounds := queue/c.Machines + 1
+	return rounds * c.AvgPlayMinutes, true
+}
+
+// formatWait renders the estimate, e.g. "预计等待 ~20 分钟"; "" when the counter has no machine setup.
+func formatWait(c Counter, value int) string {
+	minutes, ok := estimateWait(c, value)
+	switch {
+	case !ok:
+		return ""
+	case value < c.Machines:
+		return "有空闲机台，无需等待"
+	default:
+		return "预计等待 ~" + strconv.Itoa(minutes) + " 分钟"
+	}
+}
+
+// predictHour averages counter name's value at hour over the archived days that recorded hourly values.
+func predictHour(days []DayArchive, name string, hour int) (avg float64, samples int) {
+	total := 0
+	for _, d := range days {
+		for _, cd := range d.Counters {
+			if cd.Name != name || len(cd.HourValues) != 24 {
+				continue
+			}
+			total += cd.HourValues[hour]
+			samples++
+		}
+	}
+	if samples == 0 {
+		return 0, 0
+	}
+	return float64(total) / float64(samples), samples
+}
+
+// handleWaitCommands handles setOrderCardMachines, orderCardWait and orderCardPredict in registered groups.
+func handleWaitCommands(ctx protocol.Context) {
+	parts := strings.Fields(strings.TrimSpace(ctx.PlainText()))
+	if len(parts) == 0 {
+		return
+	}
+	prefix := ctx.CommandPrefix()
+	cmd, ok := strings.CutPrefix(parts[0], prefix)
+	if !ok || (cmd != cmdSetMachines && cmd != cmdWait && cmd != cmdPredict) {
+		return
+	}
+	gid := ctx.GroupID()
+	if gid == "" || gid == "0" {
+		return
+	}
+	if cmd == cmdSetMachines && !isGroupAdmin(ctx) {
+		return
+	}
+	s := getStore()
+	if s == nil || !isGroupRegistered(s, gid) {
+		return
+	}
+	defer lockGroup(gid)()
+	data, found := loadGroupData(s, gid)
+	if !found || len(data.Counters) == 0 {
+		return
+	}
+	// pick resolves the optional trailing counter name; the only counter is the default.
+	pick := func(args []string, usage string) (int, bool) {
+		if len(args) > 0 {
+			i := data.counterIndex(args[0])
+			if i < 0 {
+				_ = ctx.SendPlainMessage("没有名为 " + args[0] + " 的计数器")
+			}
+			return i, i >= 0
+		}
+		if len(data.Counters) != 1 {
+			_ = ctx.SendPlainMessage("本群有多个计数器，请指定名称: " + usage)
+			return -1, false
+		}
+		return 0, true
+	}
+	switch cmd {
+	case cmdSetMachines:
+		// {prefix}setOrderCardMachines machines minutes [counter]
+		usage := prefix + cmdSetMachines + " <机台数> <每局分钟> [计数器名称]"
+		if len(parts) < 3 {
+			_ = ctx.SendPlainMessage("用法: " + usage + "\n机台数为 0 表示不估算等待时间")
+			return
+		}
+		machines, err1 := strconv.Atoi(parts[1])
+		minutes, err2 := strconv.Atoi(parts[2])
+		if err1 != nil || err2 != nil || machines < 0 || machines > maxMachines || minutes <= 0 || minutes > maxPlayMinutes {
+			_ = ctx.SendPlainMessage("机台数需在 0-" + strconv.Itoa(maxMachines) + " 之间，每局分钟需在 1-" + strconv.Itoa(maxPlayMinutes) + " 之间")
+			return
+		}
+		i, ok := pick(parts[3:], usage)
+		if !ok {
+			return
+		}
+		c := &data.Counters[i]
+		c.Machines, c.AvgPlayMinutes = machines, minutes
+		if saveGroupData(s, gid, data) != nil {
+			_ = ctx.SendPlainMessage("保存设置失败")
+			return
+		}
+		_ = ctx.SendPlainMessage("已设置计数器 " + c.Name + "：" + strconv.Itoa(machines) + " 台，每局约 " + strconv.Itoa(minutes) + " 分钟")
+	case cmdWait:
+		lines := make([]string, 0, len(data.Counters))
+		for _, c := range data.Counters {
+			w := formatWait(c, c.Value)
+			if w == "" {
+				w = "未设置机台数（" + prefix + cmdSetMachines + "）"
+			}
+			lines = append(lines, "【"+c.Name+"】"+strconv.Itoa(c.Value)+" 人，"+w)
+		}
+		_ = ctx.SendPlainMessage(strings.Join(lines, "\n"))
+	case cmdPredict:
+		// {prefix}orderCardPredict [hour] [counter]; hour defaults to the next hour in the group's timezone.
+		usage := prefix + cmdPredict + " [0-23] [计数器名称]"
+		loc := loadGroupConfig(s, gid).location()
+		hour := (time.Now().In(loc).Hour() + 1) % 24
+		args := parts[1:]
+		if len(args) > 0 {
+			if h, err := strconv.Atoi(args[0]); err == nil {
+				if h < 0 || h > 23 {
+					_ = ctx.SendPlainMessage("用法: " + usage)
+					return
+				}
+				hour, args = h, args[1:]
+			}
+		}
+		i, ok := pick(args, usage)
+		if !ok {
+			return
+		}
+		c := data.Counters[i]
+		avg, samples := predictHour(loadArchive(s, gid), c.Name, hour)
+		if samples == 0 {
+			_ = ctx.SendPlainMessage("【" + c.Name + "】还没有足够的历史记录，明天再来看看吧")
+			return
+		}
+		expected := int(avg + 0.5)
+		msg := "【" + c.Name + "】根据近 " + strconv.Itoa(samples) + " 天记录，" + strconv.Itoa(hour) + ":00 左右预计约 " + strconv.Itoa(expected) + " 人"
+		if w := formatWait(c, expected); w != "" {
+			msg += "，" + w
+		}
+		_ = ctx.SendPlainMessage(msg)
+	}
+}